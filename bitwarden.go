@@ -0,0 +1,627 @@
+package pwsafe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BitwardenTimeLayout is how Bitwarden writes times, always in UTC.
+const BitwardenTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// the item types
+const (
+	bitwardenLogin    = 1
+	bitwardenNote     = 2
+	bitwardenCard     = 3
+	bitwardenIdentity = 4
+)
+
+// the custom field types
+const (
+	bitwardenText   = 0
+	bitwardenHidden = 1
+)
+
+// the custom field that keeps the maximum size of the password history,
+// which Bitwarden doesn't have
+const bitwardenHistoryMax = "PasswordHistoryMax"
+
+// Bitwarden folder UUIDs are made from the group path in this namespace, so
+// the same safe always gives the same file.
+var bitwardenFolderSpace = uuid.MustParse("0f1b2c4e-7a9d-4b3e-8c5f-6d2e1a0b9c37")
+
+// the fields with a place of their own in each type of item
+var (
+	bitwardenLoginFields = []byte{UUID, Group, Title, Notes, Username, Password, URL, TwoFactorKey,
+		CreationTime, LastModificationTime, PasswordModificationTime}
+	bitwardenCardFields = []byte{UUID, Group, Title, Notes, CreditCardNumber, CreditCardVerifValue,
+		CreditCardExpiration, CreationTime, LastModificationTime}
+)
+
+type bitwardenExport struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	ID              string                     `json:"id"`
+	FolderID        string                     `json:"folderId,omitempty"`
+	Type            int                        `json:"type"`
+	Name            string                     `json:"name"`
+	Notes           string                     `json:"notes,omitempty"`
+	Favorite        bool                       `json:"favorite"`
+	Fields          []bitwardenField           `json:"fields,omitempty"`
+	Login           *bitwardenLoginItem        `json:"login,omitempty"`
+	SecureNote      *bitwardenSecureNote       `json:"secureNote,omitempty"`
+	Card            *bitwardenCardItem         `json:"card,omitempty"`
+	Identity        map[string]interface{}     `json:"identity,omitempty"`
+	PasswordHistory []bitwardenPasswordHistory `json:"passwordHistory,omitempty"`
+	RevisionDate    string                     `json:"revisionDate,omitempty"`
+	CreationDate    string                     `json:"creationDate,omitempty"`
+}
+
+type bitwardenField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+type bitwardenLoginItem struct {
+	URIs                 []bitwardenURI `json:"uris,omitempty"`
+	Username             string         `json:"username,omitempty"`
+	Password             string         `json:"password,omitempty"`
+	TOTP                 string         `json:"totp,omitempty"`
+	PasswordRevisionDate string         `json:"passwordRevisionDate,omitempty"`
+}
+
+type bitwardenURI struct {
+	URI string `json:"uri"`
+}
+
+type bitwardenSecureNote struct {
+	Type int `json:"type"`
+}
+
+type bitwardenCardItem struct {
+	CardholderName string `json:"cardholderName,omitempty"`
+	Brand          string `json:"brand,omitempty"`
+	Number         string `json:"number,omitempty"`
+	ExpMonth       string `json:"expMonth,omitempty"`
+	ExpYear        string `json:"expYear,omitempty"`
+	Code           string `json:"code,omitempty"`
+}
+
+type bitwardenPasswordHistory struct {
+	LastUsedDate string `json:"lastUsedDate"`
+	Password     string `json:"password"`
+}
+
+// Unmapped is something that has no place of its own in the format being
+// converted to.
+type Unmapped struct {
+	// the title of the record, or empty for the safe itself
+	Title string
+	What  string
+	// where it was kept instead, or empty if it was left out
+	KeptIn string
+}
+
+func (u Unmapped) String() string {
+	s := u.What
+	if u.Title != "" {
+		s = fmt.Sprintf("%q: %s", u.Title, u.What)
+	}
+	if u.KeptIn == "" {
+		return s + " left out"
+	}
+	return s + " kept in " + u.KeptIn
+}
+
+// WriteBitwardenJSON writes the safe as Bitwarden's unencrypted JSON export.
+// Groups become folders, with the names separated by slashes.  Records
+// become logins, or cards when they only have card details, or secure notes
+// when they have neither.  Fields an item has no place for are written as
+// custom fields named by FieldName, so ReadBitwardenJSON gives back the same
+// records.  Those, and the headers that are left out, are returned.
+func WriteBitwardenJSON(w io.Writer, v3 *V3File) ([]Unmapped, error) {
+	var unmapped []Unmapped
+	export := bitwardenExport{Folders: []bitwardenFolder{}, Items: []bitwardenItem{}}
+	folders := make(map[string]string)
+	addFolder := func(group string) string {
+		parts := SplitGroupPath(group)
+		var id string
+		for i := range parts {
+			name := strings.Join(parts[:i+1], "/")
+			if _, ok := folders[name]; !ok {
+				folders[name] = uuid.NewSHA1(bitwardenFolderSpace, []byte(name)).String()
+				export.Folders = append(export.Folders, bitwardenFolder{ID: folders[name], Name: name})
+				if strings.Contains(parts[i], "/") {
+					unmapped = append(unmapped, Unmapped{What: fmt.Sprintf("the / in group %q", group), KeptIn: "a subfolder"})
+				}
+			}
+			id = folders[name]
+		}
+		return id
+	}
+
+	for _, h := range v3.Headers {
+		switch h.Type {
+		case EmptyGroups:
+			if s, ok := h.Data.(string); ok {
+				addFolder(s)
+			}
+		case Version, UUID, EndOfEntry:
+		default:
+			unmapped = append(unmapped, Unmapped{What: "header " + HeaderName(h.Type)})
+		}
+	}
+
+	for _, p := range v3.Passwords {
+		item, u := newBitwardenItem(&p)
+		if group := p.Text(Group); group != "" {
+			item.FolderID = addFolder(group)
+		}
+		export.Items = append(export.Items, item)
+		unmapped = append(unmapped, u...)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return unmapped, enc.Encode(export)
+}
+
+func newBitwardenItem(p *PasswordRecord) (bitwardenItem, []Unmapped) {
+	u, ok := p.ID()
+	if !ok {
+		u = uuid.New()
+	}
+	item := bitwardenItem{
+		ID:           u.String(),
+		Type:         bitwardenNote,
+		Name:         p.Text(Title),
+		Notes:        p.Text(Notes),
+		CreationDate: bitwardenTime(p, CreationTime),
+		RevisionDate: bitwardenTime(p, LastModificationTime),
+	}
+	has := func(types ...byte) bool {
+		for _, t := range types {
+			if _, ok := p.Fields[t]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	mapped := []byte{UUID, Group, Title, Notes, CreationTime, LastModificationTime}
+	switch {
+	case has(Username, Password, URL, TwoFactorKey):
+		item.Type = bitwardenLogin
+		item.Login = &bitwardenLoginItem{
+			Username:             p.Text(Username),
+			Password:             p.Text(Password),
+			PasswordRevisionDate: bitwardenTime(p, PasswordModificationTime),
+		}
+		if p.Text(URL) != "" {
+			item.Login.URIs = []bitwardenURI{{URI: p.Text(URL)}}
+		}
+		if key, ok := p.Fields[TwoFactorKey].Data.([]byte); ok {
+			item.Login.TOTP = totpEncoding.EncodeToString(key)
+		}
+		mapped = bitwardenLoginFields
+	case has(CreditCardNumber, CreditCardVerifValue, CreditCardExpiration):
+		item.Type = bitwardenCard
+		item.Card = &bitwardenCardItem{
+			Number: p.Text(CreditCardNumber),
+			Code:   p.Text(CreditCardVerifValue),
+		}
+		mapped = bitwardenCardFields
+		if month, year, ok := cardExpiry(p.Text(CreditCardExpiration)); ok {
+			item.Card.ExpMonth, item.Card.ExpYear = month, year
+		} else {
+			mapped = slices.DeleteFunc(slices.Clone(mapped), func(t byte) bool {
+				return t == CreditCardExpiration
+			})
+		}
+	default:
+		item.SecureNote = &bitwardenSecureNote{}
+	}
+
+	var unmapped []Unmapped
+	for _, k := range p.sortedFieldKey() {
+		f := p.Fields[k]
+		if slices.Contains(mapped, k) && bitwardenFits(k, f.Data) {
+			continue
+		}
+		if k == PasswordHistory {
+			// only if it comes out the same, otherwise it's kept as it is
+			if h, err := ParseHistory(p.Text(k)); err == nil && h.String() == p.Text(k) && h.Enabled {
+				for _, e := range h.Entries {
+					item.PasswordHistory = append(item.PasswordHistory, bitwardenPasswordHistory{
+						LastUsedDate: time.Unix(int64(e.Changed), 0).UTC().Format(BitwardenTimeLayout),
+						Password:     e.Password,
+					})
+				}
+				item.Fields = append(item.Fields, bitwardenField{Name: bitwardenHistoryMax, Value: strconv.Itoa(h.Max)})
+				continue
+			}
+		}
+		fieldType := bitwardenText
+		if slices.Contains(SecretFields, k) {
+			fieldType = bitwardenHidden
+		}
+		item.Fields = append(item.Fields, bitwardenField{Name: FieldName(k), Value: customValue(&f), Type: fieldType})
+		unmapped = append(unmapped, Unmapped{Title: p.Text(Title), What: FieldName(k), KeptIn: "a custom field"})
+	}
+	return item, unmapped
+}
+
+// bitwardenFits is true when the value of the field can be written in
+// Bitwarden's place for it and read back the same.
+func bitwardenFits(typeID byte, data interface{}) bool {
+	switch d := data.(type) {
+	case uuid.UUID:
+		return typeID == UUID
+	case uint32:
+		return slices.Contains(timeFields, typeID)
+	case []byte:
+		return typeID == TwoFactorKey && len(d) > 0
+	case string:
+		// empty strings are left out
+		return slices.Contains(textFields, typeID) && d != ""
+	}
+	return false
+}
+
+func bitwardenTime(p *PasswordRecord, typeID byte) string {
+	t, ok := p.Time(typeID)
+	if !ok {
+		return ""
+	}
+	return time.Unix(int64(t), 0).UTC().Format(BitwardenTimeLayout)
+}
+
+// cardExpiry splits an expiry date written MM/YYYY, the only way that can
+// be put back together the same.
+func cardExpiry(s string) (month, year string, ok bool) {
+	m, y, found := strings.Cut(s, "/")
+	n, err := strconv.Atoi(m)
+	if !found || err != nil || n < 1 || n > 12 || len(m) != 2 || len(y) != 4 {
+		return "", "", false
+	}
+	if _, err := strconv.Atoi(y); err != nil {
+		return "", "", false
+	}
+	return strconv.Itoa(n), y, true
+}
+
+// ReadBitwardenJSON reads Bitwarden's unencrypted JSON export.  Custom fields
+// named after a field, as WriteBitwardenJSON writes them, become that field.
+// Anything else without a field of its own, like other custom fields, extra
+// URIs, identities and text in keys it doesn't know, is added to the notes
+// and returned.  Other values in keys it doesn't know, like passkeys, are
+// left out and returned.
+func ReadBitwardenJSON(r io.Reader) (V3File, []Unmapped, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return V3File{}, nil, err
+	}
+	// the items are read one at a time to find what they have that isn't
+	// read
+	var export struct {
+		Encrypted bool              `json:"encrypted"`
+		Folders   []bitwardenFolder `json:"folders"`
+		Items     []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return V3File{}, nil, err
+	}
+	if export.Encrypted {
+		return V3File{}, nil, fmt.Errorf("the export is encrypted, export it again unencrypted")
+	}
+
+	var v3 V3File
+	var unmapped []Unmapped
+	for _, u := range unknownJSON(data, reflect.TypeOf(export), "") {
+		unmapped = append(unmapped, Unmapped{What: u.path})
+	}
+	folders := make(map[string]string)
+	used := make(map[string]bool)
+	for _, f := range export.Folders {
+		folders[f.ID] = JoinGroupPath(strings.Split(f.Name, "/"))
+	}
+	for _, raw := range export.Items {
+		var item bitwardenItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return V3File{}, nil, err
+		}
+		p, u, err := item.record(folders[item.FolderID], unknownJSON(raw, reflect.TypeOf(item), ""))
+		if err != nil {
+			return V3File{}, nil, err
+		}
+		v3.Passwords = append(v3.Passwords, p)
+		unmapped = append(unmapped, u...)
+		used[item.FolderID] = true
+	}
+
+	// folders without items or subfolders are the empty groups
+	for _, f := range export.Folders {
+		if used[f.ID] {
+			continue
+		}
+		parent := false
+		for _, other := range export.Folders {
+			if strings.HasPrefix(other.Name, f.Name+"/") {
+				parent = true
+				break
+			}
+		}
+		if !parent {
+			v3.Headers = append(v3.Headers, HeaderRecord{Type: EmptyGroups, Data: folders[f.ID]})
+		}
+	}
+	return v3, unmapped, nil
+}
+
+func (item *bitwardenItem) record(group string, unknown []jsonValue) (PasswordRecord, []Unmapped, error) {
+	p := NewPasswordRecord()
+	var unmapped []Unmapped
+	var extra []string
+	keep := func(what, value string) {
+		extra = append(extra, what+": "+value)
+		unmapped = append(unmapped, Unmapped{Title: item.Name, What: what, KeptIn: "the notes"})
+	}
+	set := func(typeID byte, value string) {
+		if value != "" {
+			p.Fields[typeID] = Field{Type: typeID, Data: value}
+		}
+	}
+	setTime := func(typeID byte, value string) error {
+		if value == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("item %q: %w", item.Name, err)
+		}
+		p.Fields[typeID] = Field{Type: typeID, Data: uint32(t.Unix())}
+		return nil
+	}
+
+	if u, err := uuid.Parse(item.ID); err == nil {
+		p.Fields[UUID] = Field{Type: UUID, Data: u}
+	} else {
+		p.Fields[UUID] = Field{Type: UUID, Data: uuid.New()}
+	}
+	set(Group, group)
+	set(Title, item.Name)
+	set(Notes, item.Notes)
+	if err := setTime(CreationTime, item.CreationDate); err != nil {
+		return PasswordRecord{}, nil, err
+	}
+	if err := setTime(LastModificationTime, item.RevisionDate); err != nil {
+		return PasswordRecord{}, nil, err
+	}
+	if item.Favorite {
+		unmapped = append(unmapped, Unmapped{Title: item.Name, What: "favorite"})
+	}
+	if item.Type < bitwardenLogin || item.Type > bitwardenIdentity {
+		unmapped = append(unmapped, Unmapped{Title: item.Name, What: fmt.Sprintf("item type %d", item.Type), KeptIn: "the notes"})
+	}
+
+	if l := item.Login; l != nil {
+		set(Username, l.Username)
+		set(Password, l.Password)
+		for i, u := range l.URIs {
+			if i == 0 {
+				set(URL, u.URI)
+			} else {
+				keep("URI", u.URI)
+			}
+		}
+		if l.TOTP != "" {
+			if key, ok := totpKey(l.TOTP); ok {
+				p.Fields[TwoFactorKey] = Field{Type: TwoFactorKey, Data: key}
+			} else {
+				keep("TOTP", l.TOTP)
+			}
+		}
+		if err := setTime(PasswordModificationTime, l.PasswordRevisionDate); err != nil {
+			return PasswordRecord{}, nil, err
+		}
+	}
+	if c := item.Card; c != nil {
+		set(CreditCardNumber, c.Number)
+		set(CreditCardVerifValue, c.Code)
+		if c.ExpMonth != "" || c.ExpYear != "" {
+			month, err := strconv.Atoi(c.ExpMonth)
+			if err != nil {
+				set(CreditCardExpiration, c.ExpMonth+"/"+c.ExpYear)
+			} else {
+				set(CreditCardExpiration, fmt.Sprintf("%02d/%s", month, c.ExpYear))
+			}
+		}
+		if c.CardholderName != "" {
+			keep("Cardholder name", c.CardholderName)
+		}
+		if c.Brand != "" {
+			keep("Brand", c.Brand)
+		}
+	}
+	if len(item.Identity) > 0 {
+		names := make([]string, 0, len(item.Identity))
+		for name := range item.Identity {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v, ok := item.Identity[name].(string); ok && v != "" {
+				keep(name, v)
+			}
+		}
+	}
+
+	if len(item.PasswordHistory) > 0 {
+		h := History{Enabled: true}
+		for _, e := range item.PasswordHistory {
+			t, err := time.Parse(time.RFC3339, e.LastUsedDate)
+			if err != nil {
+				return PasswordRecord{}, nil, fmt.Errorf("item %q: %w", item.Name, err)
+			}
+			h.Entries = append(h.Entries, HistoryEntry{Changed: uint32(t.Unix()), Password: e.Password})
+		}
+		sort.SliceStable(h.Entries, func(i, j int) bool {
+			return h.Entries[i].Changed < h.Entries[j].Changed
+		})
+		if len(h.Entries) > 0xff {
+			h.Entries = h.Entries[len(h.Entries)-0xff:]
+		}
+		h.Max = len(h.Entries)
+		for _, f := range item.Fields {
+			if n, err := strconv.Atoi(f.Value); f.Name == bitwardenHistoryMax && err == nil && n >= h.Max && n <= 0xff {
+				h.Max = n
+			}
+		}
+		p.Fields[PasswordHistory] = Field{Type: PasswordHistory, Data: h.String()}
+	}
+
+	for _, f := range item.Fields {
+		if f.Name == bitwardenHistoryMax {
+			continue
+		}
+		typeID, ok := customFieldTypes[f.Name]
+		if _, taken := p.Fields[typeID]; !ok || taken || f.Type != bitwardenText && f.Type != bitwardenHidden {
+			keep(f.Name, f.Value)
+			continue
+		}
+		addCustomField(&p, typeID, f.Value)
+	}
+	for _, u := range unknown {
+		var s string
+		if err := json.Unmarshal(u.value, &s); err == nil {
+			keep(u.path, s)
+		} else {
+			unmapped = append(unmapped, Unmapped{Title: item.Name, What: u.path})
+		}
+	}
+	appendNotes(&p, extra)
+	return p, unmapped, nil
+}
+
+// totpKey decodes a TOTP secret, either on its own in base32 or in an
+// otpauth URI with nothing else in it that would be lost.
+func totpKey(s string) ([]byte, bool) {
+	if strings.HasPrefix(s, "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil || len(u.Query()) != 1 {
+			return nil, false
+		}
+		s = u.Query().Get("secret")
+	}
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	key, err := totpEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(key) == 0 {
+		return nil, false
+	}
+	return key, true
+}
+
+// jsonValue is a value in a JSON key that isn't read.
+type jsonValue struct {
+	// the keys to it, separated by dots
+	path  string
+	value json.RawMessage
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// unknownJSON finds the values that aren't empty in the keys of the JSON
+// that t has no field for, with those in objects as values of their own.
+func unknownJSON(data json.RawMessage, t reflect.Type, path string) []jsonValue {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		var values []json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return nil
+		}
+		var unknown []jsonValue
+		for i, v := range values {
+			// each one can have different values
+			index := fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i)
+			unknown = append(unknown, unknownJSON(v, t.Elem(), index)...)
+		}
+		return unknown
+	case reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			// encoding/json doesn't mind the case
+			fields[strings.ToLower(name)] = t.Field(i).Type
+		}
+		var object map[string]json.RawMessage
+		json.Unmarshal(data, &object)
+		var unknown []jsonValue
+		for _, key := range jsonKeys(data) {
+			if ft, ok := fields[strings.ToLower(key)]; ok {
+				unknown = append(unknown, unknownJSON(object[key], ft, path+key+".")...)
+			} else {
+				unknown = append(unknown, jsonValues(object[key], path+key)...)
+			}
+		}
+		return unknown
+	}
+	return nil
+}
+
+// jsonValues splits objects into their values, leaving out empty ones.
+func jsonValues(data json.RawMessage, path string) []jsonValue {
+	switch strings.TrimSpace(string(data)) {
+	case "null", `""`, "0", "false", "[]", "{}":
+		return nil
+	}
+	keys := jsonKeys(data)
+	if keys == nil {
+		return []jsonValue{{path, data}}
+	}
+	var object map[string]json.RawMessage
+	json.Unmarshal(data, &object)
+	var values []jsonValue
+	for _, key := range keys {
+		values = append(values, jsonValues(object[key], path+"."+key)...)
+	}
+	return values
+}
+
+// jsonKeys gives the sorted keys of a JSON object, or nil if it isn't one.
+func jsonKeys(data json.RawMessage) []string {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil || object == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
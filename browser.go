@@ -0,0 +1,122 @@
+package pwsafe
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the columns of a browser export that become fields.  Any others are
+// ignored.
+var (
+	chromeColumns = map[string]byte{
+		"name":     Title,
+		"url":      URL,
+		"username": Username,
+		"password": Password,
+		"note":     Notes,
+	}
+	firefoxColumns = map[string]byte{
+		"url":                 URL,
+		"username":            Username,
+		"password":            Password,
+		"timeLastUsed":        LastAccessTime,
+		"timePasswordChanged": PasswordModificationTime,
+	}
+)
+
+// ReadChromeCSV reads the passwords exported by Chrome, or another browser
+// based on it, as new records in the group.
+func ReadChromeCSV(r io.Reader, group string) (V3File, error) {
+	return readBrowserCSV(r, group, "Chrome", chromeColumns)
+}
+
+// ReadFirefoxCSV reads the passwords exported by Firefox as new records in
+// the group.
+func ReadFirefoxCSV(r io.Reader, group string) (V3File, error) {
+	return readBrowserCSV(r, group, "Firefox", firefoxColumns)
+}
+
+// readBrowserCSV gives each row a new UUID and the current time as its
+// creation time.  Rows without a title are named after the site.
+func readBrowserCSV(r io.Reader, group, browser string, columns map[string]byte) (V3File, error) {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	names, err := c.Read()
+	if err != nil {
+		return V3File{}, fmt.Errorf("not a %s export: %w", browser, err)
+	}
+	types := make([]byte, len(names))
+	found := make(map[byte]bool)
+	for i, name := range names {
+		// a byte order mark isn't part of the name
+		name = strings.TrimPrefix(name, "\ufeff")
+		if t, ok := columns[name]; ok {
+			types[i] = t
+			found[t] = true
+		}
+	}
+	for _, t := range []byte{URL, Username, Password} {
+		if !found[t] {
+			return V3File{}, fmt.Errorf("not a %s export: no %s column", browser, strings.ToLower(FieldName(t)))
+		}
+	}
+
+	now := uint32(time.Now().Unix())
+	var v3 V3File
+	for {
+		row, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return V3File{}, err
+		}
+		p := NewPasswordRecord()
+		p.Fields[UUID] = Field{Type: UUID, Data: uuid.New()}
+		p.Fields[CreationTime] = Field{Type: CreationTime, Data: now}
+		if group != "" {
+			p.Fields[Group] = Field{Type: Group, Data: group}
+		}
+		for i, value := range row {
+			if i >= len(types) || types[i] == 0 || value == "" {
+				continue
+			}
+			t := types[i]
+			if t == LastAccessTime || t == PasswordModificationTime {
+				// Firefox times are in milliseconds, and have to fit in
+				// a time_t
+				ms, err := strconv.ParseUint(value, 10, 64)
+				if err != nil || ms/1000 > math.MaxUint32 {
+					line, _ := c.FieldPos(i)
+					return V3File{}, fmt.Errorf("line %d: bad %s %q", line, names[i], value)
+				}
+				p.Fields[t] = Field{Type: t, Data: uint32(ms / 1000)}
+				continue
+			}
+			p.Fields[t] = Field{Type: t, Data: value}
+		}
+		if p.Text(Title) == "" {
+			p.Fields[Title] = Field{Type: Title, Data: siteName(p.Text(URL))}
+		}
+		v3.Passwords = append(v3.Passwords, p)
+	}
+	return v3, nil
+}
+
+// siteName is the host of the URL without a leading www, or the URL if it
+// doesn't have one.
+func siteName(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Hostname() == "" {
+		return s
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
@@ -0,0 +1,97 @@
+package pwsafe_test
+
+import (
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fields lists the fields of the records without the UUID and creation
+// time, which are new each time.
+func fields(v3 pwsafe.V3File) []map[byte]interface{} {
+	var all []map[byte]interface{}
+	for _, p := range v3.Passwords {
+		m := make(map[byte]interface{})
+		for k, f := range p.Fields {
+			if k != pwsafe.UUID && k != pwsafe.CreationTime {
+				m[k] = f.Data
+			}
+		}
+		all = append(all, m)
+	}
+	return all
+}
+
+func TestReadChromeCSV(t *testing.T) {
+	c := "\ufeffname,url,username,password,note\n" +
+		"example.com,https://example.com/login,user,secret,\n" +
+		",https://www.bank.example/,me,pin,\"line 1\nline 2\"\n"
+	v3, err := pwsafe.ReadChromeCSV(strings.NewReader(c), "Chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[byte]interface{}{
+		{
+			pwsafe.Group:    "Chrome",
+			pwsafe.Title:    "example.com",
+			pwsafe.URL:      "https://example.com/login",
+			pwsafe.Username: "user",
+			pwsafe.Password: "secret",
+		},
+		{
+			pwsafe.Group:    "Chrome",
+			pwsafe.Title:    "bank.example",
+			pwsafe.URL:      "https://www.bank.example/",
+			pwsafe.Username: "me",
+			pwsafe.Password: "pin",
+			pwsafe.Notes:    "line 1\nline 2",
+		},
+	}
+	if diff := cmp.Diff(expected, fields(v3)); diff != "" {
+		t.Errorf("Unexpected records (-want +got):\n%s\n", diff)
+	}
+	for _, p := range v3.Passwords {
+		if _, ok := p.ID(); !ok {
+			t.Error("Expected a UUID")
+		}
+		if _, ok := p.Time(pwsafe.CreationTime); !ok {
+			t.Error("Expected a creation time")
+		}
+	}
+}
+
+func TestReadFirefoxCSV(t *testing.T) {
+	c := `"url","username","password","httpRealm","formActionOrigin","guid","timeCreated","timeLastUsed","timePasswordChanged"
+"https://example.com","user","secret",,"https://example.com","{0b6ee1d4-4f4f-4a8e-8f0b-1a1a1a1a1a1a}","1600000000000","1600000100000","1600000050000"
+`
+	v3, err := pwsafe.ReadFirefoxCSV(strings.NewReader(c), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[byte]interface{}{
+		{
+			pwsafe.Title:                    "example.com",
+			pwsafe.URL:                      "https://example.com",
+			pwsafe.Username:                 "user",
+			pwsafe.Password:                 "secret",
+			pwsafe.LastAccessTime:           uint32(1600000100),
+			pwsafe.PasswordModificationTime: uint32(1600000050),
+		},
+	}
+	if diff := cmp.Diff(expected, fields(v3)); diff != "" {
+		t.Errorf("Unexpected records (-want +got):\n%s\n", diff)
+	}
+
+	if _, err := pwsafe.ReadFirefoxCSV(strings.NewReader("name,url\n"), ""); err == nil {
+		t.Error("Expected an error for a file without a password column")
+	}
+	for _, ms := range []string{"-1000", "4294967296000", "soon"} {
+		in := "url,username,password,timeLastUsed\nhttps://example.com,user,secret," + ms + "\n"
+		_, err := pwsafe.ReadFirefoxCSV(strings.NewReader(in), "")
+		if err == nil || err.Error() != `line 2: bad timeLastUsed "`+ms+`"` {
+			t.Errorf("Expected a bad time for %s, got %v", ms, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/colinnewell/pwsafe-de-dup/kdbx"
+	"github.com/google/uuid"
+)
+
+// convert writes a safe as a KeePass KDBX 4 file, or the other way round,
+// going by the file names.  Both use the same master password.
+func convert(args []string) {
+	o := newOptions("convert", "in.psafe3 out.kdbx | in.kdbx out.psafe3")
+	cipherName := o.flags.String("cipher", "chacha20", "How to encrypt KDBX files: "+strings.Join(kdbx.CipherNames(), ", "))
+	files := o.parse(args, 2)
+	in, out := files[0], files[1]
+
+	c, err := kdbx.ParseCipher(*cipherName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case isKDBX(out) && !isKDBX(in):
+		pwFile := o.load(in)
+		opts := kdbx.DefaultOptions
+		opts.Cipher = c
+		err := writeAtomic(out, func(f *os.File) error {
+			return kdbx.Write(f, &pwFile, o.password, opts)
+		})
+		if err != nil {
+			log.Fatalf("%s: %s", out, err)
+		}
+	case isKDBX(in) && !isKDBX(out):
+		password := o.masterPassword()
+		pwFile := readSource(in, func(r io.Reader) (pwsafe.V3File, error) {
+			return kdbx.Read(r, password)
+		})
+		// the headers a new safe starts with
+		pwFile.Headers = append([]pwsafe.HeaderRecord{
+			{Type: pwsafe.Version, Data: "3.0"},
+			{Type: pwsafe.UUID, Data: uuid.New()},
+		}, pwFile.Headers...)
+		o.write(out, &pwFile)
+	default:
+		log.Fatal("One of the files must be a .kdbx file and the other a safe")
+	}
+}
+
+func isKDBX(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".kdbx")
+}
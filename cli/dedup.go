@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/uuid"
+)
+
+func dedup(args []string) {
+	o := newOptions("dedup", "in.psafe3 [out.psafe3]")
+	displayDuplicates := o.flags.Bool("display-duplicates", false, "Display duplicates")
+	keep := o.flags.String("keep", "first", "Which duplicate to keep: "+strings.Join(pwsafe.KeepPolicyNames(), ", "))
+	match := o.flags.String("match", "exact", "What counts as a duplicate: "+strings.Join(pwsafe.MatchNames(), ", "))
+	merge := o.flags.Bool("merge", false, "Merge the fields of duplicates rather than dropping them")
+	conflictCopies := o.flags.Bool("conflict-copies", false, "Collapse copies made by the client when merging")
+	uuidConflicts := o.flags.String("uuid-conflicts", "new-uuid", "How to resolve different records with the same UUID: "+strings.Join(pwsafe.UUIDResolutionNames(), ", "))
+	dryRun := o.flags.Bool("dry-run", false, "Report what would change without writing the output file")
+	o.flags.StringVar(&o.format, "report", "text", "Report format, the same as -format")
+	removedOut := o.flags.String("removed-out", "", "Write the records that were removed to this safe")
+	interactive := o.flags.Bool("interactive", false, "Review each group of duplicates or near duplicates by hand")
+	threshold := o.flags.Float64("threshold", pwsafe.DefaultNearDuplicateThreshold, "Similarity score (0-1) for near duplicates in interactive mode")
+	files := o.parse(args, 1)
+
+	keepPolicy, err := pwsafe.ParseKeepPolicy(*keep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	matchMode, err := pwsafe.ParseMatch(*match)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uuidResolution, err := pwsafe.ParseUUIDResolution(*uuidConflicts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := pwsafe.DedupOptions{
+		Keep:  keepPolicy,
+		Match: matchMode,
+		Merge: *merge,
+	}
+	if *interactive && o.json() {
+		log.Fatal("Can't use a JSON report in interactive mode")
+	}
+	if *interactive {
+		// the choices are made by hand instead
+		o.flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "keep", "match", "merge":
+				log.Fatalf("Can't use -%s in interactive mode", f.Name)
+			}
+		})
+	}
+	if len(files) < 2 && !*dryRun {
+		log.Fatal("Must specify an output file or -dry-run")
+	}
+
+	pwFile := o.load(files[0])
+
+	var removed []pwsafe.PasswordRecord
+	report := pwsafe.NewReport(len(pwFile.Passwords))
+	passwords, changes := pwsafe.ResolveUUIDConflicts(pwFile.Passwords, uuidResolution)
+	report.AddUUIDChanges(changes)
+	pwFile.Passwords = passwords
+	for _, c := range changes {
+		if c.Merged {
+			removed = append(removed, c.Record)
+		}
+	}
+
+	if *conflictCopies {
+		copies := pwsafe.CollapseConflictCopies(pwFile.Passwords)
+		report.AddConflictCopies(copies)
+		pwFile.Passwords = copies.Passwords
+		for _, c := range copies.Collapsed {
+			removed = append(removed, c.Copy)
+		}
+	}
+
+	var result pwsafe.DedupResult
+	if *interactive {
+		var ok bool
+		result, ok = newReviewer(os.Stdin, os.Stdout).review(pwFile.Passwords, *threshold)
+		if !ok {
+			fmt.Println("Nothing written")
+			return
+		}
+	} else {
+		result = pwsafe.Dedup(pwFile.Passwords, opts)
+	}
+	report.AddDedup(result, opts)
+	pwFile.Passwords = result.Passwords
+	removed = append(removed, result.Removed...)
+
+	if o.json() {
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if *displayDuplicates {
+			for _, g := range result.Groups {
+				for _, p := range g.Records {
+					fmt.Println(p.String())
+				}
+			}
+		}
+		printReport(&report)
+	}
+
+	if *dryRun {
+		return
+	}
+
+	o.write(files[1], &pwFile)
+
+	if *removedOut != "" {
+		q := quarantine(pwFile.Headers, removed)
+		o.write(*removedOut, &q)
+	}
+}
+
+// quarantine makes the safe the removed records are written to.  It's a
+// different file so it gets a database UUID of its own.
+func quarantine(headers []pwsafe.HeaderRecord, removed []pwsafe.PasswordRecord) pwsafe.V3File {
+	// identical copies only need saving once, and the quarantine safe
+	// mustn't have UUID conflicts of its own
+	seen := make(map[[32]byte]bool)
+	removed = slices.DeleteFunc(slices.Clone(removed), func(p pwsafe.PasswordRecord) bool {
+		sha := p.Sha256()
+		if seen[sha] {
+			return true
+		}
+		seen[sha] = true
+		return false
+	})
+	removed, _ = pwsafe.ResolveUUIDConflicts(removed, pwsafe.ResolveNewUUID)
+	q := pwsafe.V3File{Passwords: removed}
+	for _, h := range headers {
+		if h.Type == pwsafe.UUID {
+			h.Data = uuid.New()
+		}
+		q.Headers = append(q.Headers, h)
+	}
+	return q
+}
+
+func printReport(report *pwsafe.Report) {
+	for _, c := range report.UUIDChanges {
+		if c.Merged {
+			fmt.Printf("UUID conflict: merged %q into %s\n", c.Record.Title, c.Record.UUID)
+		} else {
+			fmt.Printf("UUID conflict: gave %q new UUID %s, was %s\n", c.Record.Title, c.NewUUID, c.Record.UUID)
+		}
+	}
+	for _, c := range report.ConflictCopies {
+		switch {
+		case len(c.Candidates) > 0:
+			fmt.Printf("Merge copy %q (%s) could be from any of %d records, left for review\n", c.Copy.Title, c.Copy.UUID, len(c.Candidates))
+		case c.Original == nil:
+			fmt.Printf("Merge copy %q (%s) has no original\n", c.Copy.Title, c.Copy.UUID)
+		case c.Collapsed:
+			fmt.Printf("Collapsed %q into %s\n", c.Copy.Title, c.Original.UUID)
+		default:
+			fmt.Printf("Merge copy %q (%s) differs from %s\n", c.Copy.Title, c.Copy.UUID, c.Original.UUID)
+		}
+	}
+	for _, g := range report.Groups {
+		switch {
+		case g.Kept == nil:
+			fmt.Printf("%d duplicates of %q: %s\n", len(g.Records), g.Records[0].Title, g.Reason)
+		case g.Merged:
+			fmt.Printf("Merged %d duplicates into %s (%s)\n", len(g.Records), g.Kept.UUID, g.Reason)
+		default:
+			fmt.Printf("Kept %s from %d duplicates (%s)\n", g.Kept.UUID, len(g.Records), g.Reason)
+		}
+	}
+	fmt.Printf("Total passwords %d, unique %d\n", report.Total, report.Unique)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func record(title string) pwsafe.PasswordRecord {
+	r := pwsafe.NewPasswordRecord()
+	r.Fields[pwsafe.UUID] = pwsafe.Field{Type: pwsafe.UUID, Data: uuid.New()}
+	r.Fields[pwsafe.Title] = pwsafe.Field{Type: pwsafe.Title, Data: title}
+	return r
+}
+
+func TestQuarantine(t *testing.T) {
+	id := uuid.New()
+	headers := []pwsafe.HeaderRecord{
+		{Type: pwsafe.Version, Data: "3.13"},
+		{Type: pwsafe.UUID, Data: id},
+		{Type: pwsafe.DatabaseName, Data: "safe"},
+	}
+	a := record("a")
+	b := record("b")
+	// a different record with the same UUID as a
+	c := record("c")
+	c.Fields[pwsafe.UUID] = a.Fields[pwsafe.UUID]
+
+	q := quarantine(headers, []pwsafe.PasswordRecord{a, b, a, c})
+
+	if len(q.Headers) != 3 {
+		t.Fatalf("Expected 3 headers, got %v", q.Headers)
+	}
+	if u, ok := q.Headers[1].Data.(uuid.UUID); !ok || u == id {
+		t.Errorf("Expected a new database UUID, got %v", q.Headers[1].Data)
+	}
+	if headers[1].Data != id {
+		t.Error("Expected the source headers to be left alone")
+	}
+	if diff := cmp.Diff(headers[2], q.Headers[2]); diff != "" {
+		t.Errorf("Unexpected header (-want +got):\n%s\n", diff)
+	}
+	if len(q.Passwords) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(q.Passwords))
+	}
+	if q.Passwords[2].Fields[pwsafe.UUID] == a.Fields[pwsafe.UUID] {
+		t.Error("Expected the conflicting record to get a new UUID")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+type jsonFieldChange struct {
+	Field  string `json:"field"`
+	Secret bool   `json:"secret,omitempty"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+type jsonRecordDiff struct {
+	pwsafe.ReportRecord
+	Fields []jsonFieldChange `json:"fields"`
+}
+
+// diff compares the records in two safes.  Like diff(1) it exits with 1 when
+// there are differences.
+func diff(args []string) {
+	o := newOptions("diff", "a.psafe3 b.psafe3")
+	files := o.parse(args, 2)
+
+	a := o.load(files[0])
+	b := o.load(files[1])
+
+	result := pwsafe.Diff(&a, &b)
+
+	if o.json() {
+		out := struct {
+			Added   []pwsafe.ReportRecord `json:"added"`
+			Removed []pwsafe.ReportRecord `json:"removed"`
+			Changed []jsonRecordDiff      `json:"changed"`
+		}{[]pwsafe.ReportRecord{}, []pwsafe.ReportRecord{}, []jsonRecordDiff{}}
+		for _, p := range result.Added {
+			out.Added = append(out.Added, pwsafe.NewReportRecord(&p))
+		}
+		for _, p := range result.Removed {
+			out.Removed = append(out.Removed, pwsafe.NewReportRecord(&p))
+		}
+		for _, c := range result.Changed {
+			d := jsonRecordDiff{ReportRecord: pwsafe.NewReportRecord(&c.B)}
+			for _, f := range c.Fields {
+				d.Fields = append(d.Fields, jsonFieldChange{pwsafe.FieldName(f.Type), f.Secret, f.Old, f.New})
+			}
+			out.Changed = append(out.Changed, d)
+		}
+		printJSON(out)
+	} else {
+		for _, p := range result.Removed {
+			fmt.Printf("- %s\n", describe(&p))
+		}
+		for _, p := range result.Added {
+			fmt.Printf("+ %s\n", describe(&p))
+		}
+		for _, c := range result.Changed {
+			fmt.Printf("~ %s\n", describe(&c.B))
+			for _, f := range c.Fields {
+				fmt.Printf("    %s\n", f)
+			}
+		}
+	}
+	if !result.Empty() {
+		os.Exit(1)
+	}
+}
+
+func describe(p *pwsafe.PasswordRecord) string {
+	return fmt.Sprintf("%s %q (%s)", p.Text(pwsafe.Group), p.Text(pwsafe.Title), recordUUID(p))
+}
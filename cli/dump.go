@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func dump(args []string) {
+	o := newOptions("dump", "file.psafe3")
+	files := o.parse(args, 1)
+
+	pwFile := o.load(files[0])
+
+	if o.json() {
+		records := []map[string]interface{}{}
+		for _, p := range pwFile.Passwords {
+			records = append(records, recordMap(&p, pwsafe.Redaction{}))
+		}
+		printJSON(struct {
+			Headers []jsonHeader             `json:"headers"`
+			Records []map[string]interface{} `json:"records"`
+		}{headerList(pwFile.Headers), records})
+		return
+	}
+
+	fmt.Println("== Headers ==")
+	for _, h := range pwFile.Headers {
+		fmt.Println(h.String())
+	}
+	fmt.Println("")
+	for _, p := range pwFile.Passwords {
+		fmt.Println(p.String())
+	}
+}
+
+// printRecords prints the records as JSON or text depending on the options,
+// masking the secrets that aren't revealed.
+func printRecords(o *options, passwords []pwsafe.PasswordRecord, r pwsafe.Redaction) {
+	if o.json() {
+		records := []map[string]interface{}{}
+		for _, p := range passwords {
+			records = append(records, recordMap(&p, r))
+		}
+		printJSON(records)
+		return
+	}
+	for _, p := range passwords {
+		fmt.Println(p.Redacted(r))
+	}
+}
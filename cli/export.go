@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func export(args []string) {
+	o := newOptions("export", "file.psafe3", "csv", "json", "pwsafe-txt", "xml", "keepass-xml", "bitwarden-json")
+	fields := o.flags.String("fields", "", "Comma separated fields for csv and json (default group,title,username,url,email,notes, and password with -include-secrets)")
+	includeSecrets := o.flags.Bool("include-secrets", false, "Write passwords and other secrets")
+	out := o.flags.String("out", "", "Write to this file, only readable by you, rather than stdout")
+	files := o.parse(args, 1)
+
+	if *fields == "" {
+		*fields = "group,title,username,url,email,notes"
+		if *includeSecrets {
+			*fields += ",password"
+		}
+	}
+	names, types, err := parseColumns(*fields, *includeSecrets)
+	if err != nil {
+		log.Fatalf("%s, add -include-secrets to export it", err)
+	}
+	if o.format != "csv" && o.format != "json" && !*includeSecrets {
+		log.Fatalf("%s always has the passwords, add -include-secrets to export it", o.format)
+	}
+
+	pwFile := o.load(files[0])
+
+	write := func(w io.Writer) error {
+		switch o.format {
+		case "json":
+			return writeJSON(w, recordRows(pwFile.Passwords, names, types))
+		case "pwsafe-txt":
+			return pwsafe.WritePlainText(w, pwFile.Passwords)
+		case "xml":
+			return pwsafe.WriteXML(w, &pwFile)
+		case "keepass-xml":
+			return pwsafe.WriteKeePassXML(w, &pwFile)
+		case "bitwarden-json":
+			unmapped, err := pwsafe.WriteBitwardenJSON(w, &pwFile)
+			// stdout may be the export
+			for _, u := range unmapped {
+				fmt.Fprintln(os.Stderr, u)
+			}
+			return err
+		}
+		return writeCSV(w, pwFile.Passwords, names, types)
+	}
+	if *out == "" {
+		err = write(os.Stdout)
+	} else {
+		// a new file, so it's only readable by us even if the old one wasn't
+		err = writeAtomic(*out, func(f *os.File) error { return write(f) })
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeCSV(w io.Writer, passwords []pwsafe.PasswordRecord, names []string, types []byte) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(names); err != nil {
+		return err
+	}
+	for _, p := range passwords {
+		var row []string
+		for _, t := range types {
+			row = append(row, p.Display(t, time.DateTime))
+		}
+		if err := c.Write(row); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// gitTextconv prints a safe as text for git diff.  The records are sorted
+// so the output only changes when they do, and secrets are masked.
+func gitTextconv(args []string) {
+	o := newOptions("git-textconv", "file.psafe3")
+	files := o.parse(args, 1)
+	requirePasswordSource(o)
+
+	pwFile := o.load(files[0])
+	writeTextconv(os.Stdout, &pwFile)
+}
+
+func writeTextconv(w io.Writer, v3 *pwsafe.V3File) {
+	headers := append([]pwsafe.HeaderRecord(nil), v3.Headers...)
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Type < headers[j].Type })
+	for _, h := range headers {
+		// it changes every time the safe is saved
+		if h.Type == pwsafe.TimestampOfLastSave {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s\n", pwsafe.HeaderName(h.Type), textconvData(h.Data))
+	}
+
+	passwords := append([]pwsafe.PasswordRecord(nil), v3.Passwords...)
+	sort.SliceStable(passwords, func(i, j int) bool {
+		return textconvKey(&passwords[i]) < textconvKey(&passwords[j])
+	})
+	for _, p := range passwords {
+		fmt.Fprintf(w, "\n== %s ==\n", describe(&p))
+		for _, t := range fieldTypes(&p) {
+			fmt.Fprintf(w, "%s: %s\n", pwsafe.FieldName(t), textconvValue(&p, t))
+		}
+	}
+}
+
+func textconvKey(p *pwsafe.PasswordRecord) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s", p.Text(pwsafe.Group), p.Text(pwsafe.Title), p.Text(pwsafe.Username), recordUUID(p))
+}
+
+func fieldTypes(p *pwsafe.PasswordRecord) []byte {
+	var types []byte
+	for k := range p.Fields {
+		types = append(types, k)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// textconvValue gives a field on one line.  Secrets are always masked the
+// same way, as anything derived from them would let the text be used to
+// guess them.
+func textconvValue(p *pwsafe.PasswordRecord, typeID byte) string {
+	if (pwsafe.Redaction{}).Hidden(typeID) {
+		return pwsafe.Masked
+	}
+	return textconvData(p.Fields[typeID].Data)
+}
+
+// textconvData gives a value on one line, with times in UTC and binary
+// values in hex.
+func textconvData(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return hex.EncodeToString(v)
+	case uint32:
+		return time.Unix(int64(v), 0).UTC().Format(time.DateTime)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// gitMergeDriver is run by git to merge a safe, with the ancestor, our
+// version and theirs.  The result replaces ours, and like any merge driver
+// it exits with 1 if there are conflicts left to sort out.
+func gitMergeDriver(args []string) {
+	if mergeDriver(args) > 0 {
+		os.Exit(1)
+	}
+}
+
+// mergeDriver merges the files git gives it and returns the number of
+// conflicts.
+func mergeDriver(args []string) int {
+	o := newOptions("git-merge-driver", "%O %A %B")
+	files := o.parse(args, 3)
+	requirePasswordSource(o)
+
+	result := threeWayMerge(o, files[0], files[1], files[2], files[1], true)
+	return len(result.Conflicts)
+}
+
+// requirePasswordSource stops git hanging on a password prompt nobody can
+// see.
+func requirePasswordSource(o *options) {
+	if o.passwordSource.interactive() && o.passwordSource.pinentry == "" {
+		log.Fatal("Needs one of -password-file, -password-fd, -password-env, -password-command or -pinentry when run by git")
+	}
+}
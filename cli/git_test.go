@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteTextconv(t *testing.T) {
+	b := login("bank", "user", "hunter2")
+	b.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "money"}
+	b.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(1600000000)}
+	b.Fields[pwsafe.TwoFactorKey] = pwsafe.Field{Type: pwsafe.TwoFactorKey, Data: []byte("12345678901234567890")}
+	a := login("shop", "user", "hunter2")
+	v3 := pwsafe.V3File{
+		Headers: []pwsafe.HeaderRecord{
+			{Type: pwsafe.TimestampOfLastSave, Data: uint32(1600000000)},
+			{Type: pwsafe.Version, Data: "3.13"},
+			{Type: 0x30, Data: []byte{0xab, 0xcd}},
+		},
+		Passwords: []pwsafe.PasswordRecord{b, a},
+	}
+
+	var out bytes.Buffer
+	writeTextconv(&out, &v3)
+
+	expected := strings.Join([]string{
+		`Version: "3.13"`,
+		`Unknown (48): abcd`,
+		``,
+		`==  "shop" (` + recordUUID(&a) + `) ==`,
+		`UUID: ` + recordUUID(&a),
+		`Title: "shop"`,
+		`Username: "user"`,
+		`Password: ********`,
+		``,
+		`== money "bank" (` + recordUUID(&b) + `) ==`,
+		`UUID: ` + recordUUID(&b),
+		`Group: "money"`,
+		`Title: "bank"`,
+		`Username: "user"`,
+		`Password: ********`,
+		`CreationTime: 2020-09-13 12:26:40`,
+		`TwoFactorKey: ********`,
+		``,
+	}, "\n")
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("Unexpected text (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestMergeDriver(t *testing.T) {
+	t.Setenv("PWSAFE_TEST_PASSWORD", "secret")
+	dir := t.TempDir()
+	r := login("bank", "user", "hunter2")
+	safe := func(name string, p pwsafe.PasswordRecord) string {
+		filename := filepath.Join(dir, name)
+		v3 := pwsafe.V3File{
+			Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.13"}},
+			Passwords: []pwsafe.PasswordRecord{p},
+		}
+		if err := writeFile(filename, &v3, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	edit := func(typeID byte, value string) pwsafe.PasswordRecord {
+		e := pwsafe.NewPasswordRecord()
+		for k, f := range r.Fields {
+			e.Fields[k] = f
+		}
+		e.Fields[typeID] = pwsafe.Field{Type: typeID, Data: value}
+		return e
+	}
+	read := func(filename string) []pwsafe.PasswordRecord {
+		v3, err := loadFile(filename, []byte("secret"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v3.Passwords
+	}
+
+	ancestor := safe("ancestor", r)
+	ours := safe("ours", edit(pwsafe.Username, "ours"))
+	theirs := safe("theirs", edit(pwsafe.URL, "https://example.com"))
+	if n := mergeDriver([]string{"-password-env", "PWSAFE_TEST_PASSWORD", ancestor, ours, theirs}); n != 0 {
+		t.Errorf("Expected no conflicts, got %d", n)
+	}
+	merged := edit(pwsafe.Username, "ours")
+	merged.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://example.com"}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{merged}, read(ours)); diff != "" {
+		t.Errorf("Unexpected merge (-want +got):\n%s\n", diff)
+	}
+
+	ours = safe("ours", edit(pwsafe.Username, "ours"))
+	theirs = safe("theirs", edit(pwsafe.Username, "theirs"))
+	// reading the password removed it
+	t.Setenv("PWSAFE_TEST_PASSWORD", "secret")
+	if n := mergeDriver([]string{"-password-env", "PWSAFE_TEST_PASSWORD", ancestor, ours, theirs}); n != 1 {
+		t.Errorf("Expected 1 conflict, got %d", n)
+	}
+	passwords := read(ours)
+	if len(passwords) != 2 || passwords[0].Text(pwsafe.Username) != "ours" || passwords[1].Text(pwsafe.Username) != "theirs" {
+		t.Errorf("Expected ours and a conflict entry with theirs, got %v", passwords)
+	}
+	if !pwsafe.IsConflictCopy(&passwords[1]) {
+		t.Errorf("Expected a conflict copy, got %s", passwords[1].String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// importRecords adds the records from the source safe that aren't already in
+// the safe.
+func importRecords(args []string) {
+	o := newOptions("import", "safe.psafe3 source out.psafe3", "psafe3", "xml", "keepass-xml", "chrome-csv", "firefox-csv", "bitwarden-json")
+	o.flags.Lookup("format").Usage = "Format of the source: " + strings.Join(o.formats, ", ")
+	group := o.flags.String("group", "Imported", "Group to put the records from a browser in")
+	files := o.parse(args, 3)
+
+	pwFile := o.load(files[0])
+	var source pwsafe.V3File
+	switch o.format {
+	case "xml":
+		source = readSource(files[1], pwsafe.ReadXML)
+	case "keepass-xml":
+		source = readSource(files[1], pwsafe.ReadKeePassXML)
+	case "bitwarden-json":
+		source = readSource(files[1], func(r io.Reader) (pwsafe.V3File, error) {
+			v3, unmapped, err := pwsafe.ReadBitwardenJSON(r)
+			// on stderr, as export does
+			for _, u := range unmapped {
+				fmt.Fprintln(os.Stderr, u)
+			}
+			return v3, err
+		})
+	case "chrome-csv":
+		source = readSource(files[1], func(r io.Reader) (pwsafe.V3File, error) {
+			return pwsafe.ReadChromeCSV(r, *group)
+		})
+	case "firefox-csv":
+		source = readSource(files[1], func(r io.Reader) (pwsafe.V3File, error) {
+			return pwsafe.ReadFirefoxCSV(r, *group)
+		})
+	default:
+		source = o.load(files[1])
+	}
+
+	// the same login counts as already there whatever its UUID, so
+	// records exported and imported again aren't added twice
+	added, skipped := pwsafe.NewCredentials(pwFile.Passwords, source.Passwords)
+	pwFile.Passwords = append(pwFile.Passwords, added...)
+	passwords, changes := pwsafe.ResolveUUIDConflicts(pwFile.Passwords, pwsafe.ResolveNewUUID)
+	pwFile.Passwords = passwords
+	for _, c := range changes {
+		fmt.Printf("UUID conflict: %s\n", c)
+	}
+
+	fmt.Printf("Imported %d records, skipped %d already in the safe\n", len(added), len(skipped))
+	o.write(files[2], &pwFile)
+}
+
+// readSource reads a file to import with one of the library's readers.
+func readSource(filename string, read func(io.Reader) (pwsafe.V3File, error)) pwsafe.V3File {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	v3, err := read(f)
+	if err != nil {
+		log.Fatalf("%s: %s", filename, err)
+	}
+	return v3
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func lint(args []string) {
+	o := newOptions("lint", "file.psafe3")
+	files := o.parse(args, 1)
+	// so the fields that can't be decoded can be listed
+	o.lenient = true
+
+	pwFile := o.load(files[0])
+
+	problems := pwFile.Validate()
+	if o.json() {
+		if problems == nil {
+			problems = []pwsafe.Problem{}
+		}
+		printJSON(problems)
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("%d problems\n", len(problems))
+	}
+	if len(problems) > 0 {
+		os.Exit(exitWarnings)
+	}
+}
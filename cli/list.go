@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func list(args []string) {
+	o := newOptions("list", "file.psafe3", "table", "tsv", "json")
+	columns := o.flags.String("columns", "group,title,username", "Comma separated fields to show, e.g. group,title,username,url,modified,uuid")
+	group := o.flags.String("group", "", "Only list records in this group or the groups below it")
+	match := o.flags.String("match", "", "Only list records with a field matching this regular expression.  Secret fields aren't searched")
+	has := o.flags.String("has", "", "Only list records that have all these comma separated fields")
+	files := o.parse(args, 1)
+
+	names, types, err := parseColumns(*columns, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := pwsafe.Filter{Group: *group}
+	if *match != "" {
+		if filter.Pattern, err = regexp.Compile(*match); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if filter.Has, err = pwsafe.ParseFieldNames(*has); err != nil {
+		log.Fatal(err)
+	}
+
+	pwFile := o.load(files[0])
+	passwords := filter.Filter(pwFile.Passwords)
+
+	switch o.format {
+	case "json":
+		printJSON(recordRows(passwords, names, types))
+	case "tsv":
+		printRows(os.Stdout, types, passwords)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		printRows(w, types, passwords)
+		w.Flush()
+	}
+}
+
+// printRows prints a heading and then a tab separated line for each record.
+func printRows(w io.Writer, types []byte, passwords []pwsafe.PasswordRecord) {
+	var heading []string
+	for _, t := range types {
+		heading = append(heading, pwsafe.FieldName(t))
+	}
+	fmt.Fprintln(w, strings.Join(heading, "\t"))
+	for _, p := range passwords {
+		var row []string
+		for _, t := range types {
+			v := p.Display(t, time.DateTime)
+			row = append(row, strings.NewReplacer("\t", " ", "\r", "", "\n", " ").Replace(v))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+}
@@ -1,72 +1,226 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"syscall"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	pwsafe "github.com/colinnewell/pwsafe-de-dup"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
-var displayDuplicates bool
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
+
+func commands() []command {
+	return []command{
+		{"dedup", "Remove duplicate records", dedup},
+		{"near-dups", "Show records that are nearly the same", nearDups},
+		{"dump", "Print the headers and records", dump},
+		{"list", "List the records, one per line", list},
+		{"show", "Show a record", show},
+		{"verify", "Check safes can be read", verify},
+		{"lint", "Check the records in a safe are consistent", lint},
+		{"diff", "Show the differences between two safes", diff},
+		{"merge", "Merge two safes", merge},
+		{"merge3", "Merge two copies of a safe using their common ancestor", merge3},
+		{"export", "Export the records", export},
+		{"import", "Import records into a safe", importRecords},
+		{"convert", "Convert a safe to or from a KeePass KDBX 4 file", convert},
+		{"passwd", "Change the master password", passwd},
+		{"git-textconv", "Print a safe for git diff", gitTextconv},
+		{"git-merge-driver", "Merge a safe for git", gitMergeDriver},
+	}
+}
 
 func main() {
-	flag.BoolVar(&displayDuplicates, "display-duplicates", false, "Display duplicates")
-	flag.Parse()
+	log.SetFlags(0)
 
-	files := flag.Args()
-	if len(files) < 2 {
-		log.Fatal("Must specify filename")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, c := range commands() {
+		if c.name == name {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		usage()
+	case strings.HasPrefix(name, "-") || exists(name):
+		// before there were commands it only did dedup, so carry on
+		// supporting that.
+		dedup(os.Args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
 	}
+}
 
-	file, err := os.Open(files[0])
-	if err != nil {
-		log.Fatal("Error while opening file", err)
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s command [flags] args\n\nCommands:\n", name)
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.description)
 	}
-	defer file.Close()
+	fmt.Fprintf(os.Stderr, "\nRun '%s command -h' for the flags each command takes.\n", name)
+}
 
-	fmt.Print("Enter Password: ")
-	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		log.Fatal(err)
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// options are the flags shared by all the commands.
+type options struct {
+	flags          *flag.FlagSet
+	format         string
+	formats        []string
+	passwordSource passwordSource
+	// read safes with fields that can't be decoded, keeping them as raw
+	// bytes
+	lenient bool
+
+	password []byte
+}
+
+// newOptions sets up the flags for a command.  The formats it can output
+// are text and json unless others are given, the first is the default.
+func newOptions(name, args string, formats ...string) *options {
+	if len(formats) == 0 {
+		formats = []string{"text", "json"}
 	}
+	o := &options{flags: flag.NewFlagSet(name, flag.ExitOnError), formats: formats}
+	o.flags.StringVar(&o.format, "format", formats[0], "Output format: "+strings.Join(formats, ", "))
+	o.passwordSource.addFlags(o)
+	o.flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n\nFlags:\n", filepath.Base(os.Args[0]), name, args)
+		o.flags.PrintDefaults()
+	}
+	return o
+}
 
-	pwFile, err := pwsafe.Load(file, bytePassword)
-	if err != nil {
+// parse parses the flags and checks there are at least n arguments left.
+func (o *options) parse(args []string, n int) []string {
+	if err := o.flags.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	if o.flags.NArg() < n {
+		o.flags.Usage()
+		os.Exit(2)
+	}
+	if !slices.Contains(o.formats, o.format) {
+		log.Fatalf("Unknown format %q", o.format)
+	}
+	if err := o.passwordSource.check(); err != nil {
+		log.Fatal(err)
+	}
+	return o.flags.Args()
+}
+
+func (o *options) json() bool {
+	return o.format == "json"
+}
 
-	uuids := make(map[[32]byte]pwsafe.PasswordRecord)
-	totalPasswords := 0
-	for _, p := range pwFile.Passwords {
-		if displayDuplicates {
-			_, ok := uuids[p.Sha256()]
-			if ok {
-				fmt.Println(p.String())
-				o := uuids[p.Sha256()]
-				fmt.Println(o.String())
-			}
+// masterPassword reads the password the first time it's needed.
+func (o *options) masterPassword() []byte {
+	if o.password == nil {
+		password, err := o.passwordSource.read("Enter Password: ")
+		if err != nil {
+			log.Fatal(err)
 		}
-		uuids[p.Sha256()] = p
-		totalPasswords++
+		o.password = password
 	}
-	var uniquePasswords []pwsafe.PasswordRecord
-	for _, v := range uuids {
-		uniquePasswords = append(uniquePasswords, v)
+	return o.password
+}
+
+// load reads a safe.  The password is read the first time it's needed and
+// tried on any other safes loaded.  When it's typed in it's only asked for
+// again if it's wrong, and the one that opens the safe is used from then on.
+func (o *options) load(filename string) pwsafe.V3File {
+	pwFile, err := loadFile(filename, o.masterPassword(), o.lenient)
+	if errors.Is(err, pwsafe.ErrIncorrectPassword) && o.passwordSource.interactive() {
+		password, perr := o.passwordSource.prompt(fmt.Sprintf("Enter Password for %s: ", filename), "Incorrect password")
+		if perr != nil {
+			log.Fatal(perr)
+		}
+		pwFile, err = loadFile(filename, password, o.lenient)
+		if err == nil {
+			o.password = password
+		}
 	}
-	fmt.Printf("Total passwords %d, unique %d\n", totalPasswords, len(uniquePasswords))
-	pwFile.Passwords = uniquePasswords
+	if err != nil {
+		log.Fatalf("%s: %s", filename, err)
+	}
+	return pwFile
+}
 
-	op, err := os.Create(files[1])
+func loadFile(filename string, password []byte, lenient bool) (pwsafe.V3File, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return pwsafe.V3File{}, err
 	}
-	defer op.Close()
+	defer file.Close()
 
-	if err := pwFile.Write(op, bytePassword); err != nil {
-		log.Fatal(err)
+	if lenient {
+		v3, _, err := pwsafe.Verify(file, password)
+		return v3, err
+	}
+	return pwsafe.Load(file, password)
+}
+
+// write saves the safe with the password that last opened a safe.
+func (o *options) write(filename string, pwFile *pwsafe.V3File) {
+	write(filename, pwFile, o.password)
+}
+
+func write(filename string, pwFile *pwsafe.V3File, password []byte) {
+	if err := writeFile(filename, pwFile, password); err != nil {
+		log.Fatalf("%s: %s", filename, err)
+	}
+}
+
+func writeFile(filename string, pwFile *pwsafe.V3File, password []byte) error {
+	return writeAtomic(filename, func(f *os.File) error {
+		return pwFile.Write(f, password)
+	})
+}
+
+// writeAtomic writes to a temporary file and then renames it into place, so
+// the original is left alone if anything goes wrong.  The file is only
+// readable by the owner.
+func writeAtomic(filename string, write func(f *os.File) error) error {
+	op, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(op.Name())
+
+	if err := write(op); err != nil {
+		op.Close()
+		return err
+	}
+	if err := op.Close(); err != nil {
+		return err
+	}
+	return os.Rename(op.Name(), filename)
+}
+
+func recordUUID(p *pwsafe.PasswordRecord) string {
+	u, ok := p.ID()
+	if !ok {
+		return "no UUID"
 	}
+	return u.String()
 }
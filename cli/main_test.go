@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(filename, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeAtomic(filename, func(f *os.File) error {
+		_, err := f.WriteString("new")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("Expected new contents, got %q", data)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestLoadRetry(t *testing.T) {
+	dir := t.TempDir()
+	// a pinentry that gives the wrong password the first time it's run
+	script := `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r line; do
+	case "$line" in
+	GETPIN)
+		if [ -e ` + dir + `/asked ]; then
+			echo "D right"
+		else
+			touch ` + dir + `/asked
+			echo "D wrong"
+		fi
+		echo OK
+		;;
+	BYE)
+		echo "OK closing connection"
+		exit 0
+		;;
+	*)
+		echo OK
+		;;
+	esac
+done
+`
+	path := filepath.Join(dir, "pinentry")
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	in := filepath.Join(dir, "in.psafe3")
+	v3 := pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.13"}},
+		Passwords: []pwsafe.PasswordRecord{record("a")},
+	}
+	if err := writeFile(in, &v3, []byte("right")); err != nil {
+		t.Fatal(err)
+	}
+
+	o := newOptions("test", "")
+	o.parse([]string{"-pinentry", path}, 0)
+	pwFile := o.load(in)
+	out := filepath.Join(dir, "out.psafe3")
+	o.write(out, &pwFile)
+
+	got, err := loadFile(out, []byte("right"), false)
+	if err != nil {
+		t.Fatalf("Expected the safe to be saved with the password that opened it: %s", err)
+	}
+	if len(got.Passwords) != 1 {
+		t.Errorf("Expected 1 record, got %d", len(got.Passwords))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// merge adds the records from the other safe to the base, picking a winner
+// when a record with the same UUID differs.
+func merge(args []string) {
+	o := newOptions("merge", "base.psafe3 other.psafe3 out.psafe3")
+	winner := o.flags.String("winner", "newest", "Which record wins when they differ: "+strings.Join(pwsafe.MergeWinnerNames(), ", "))
+	files := o.parse(args, 3)
+
+	w, err := pwsafe.ParseMergeWinner(*winner)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if w == pwsafe.WinnerAsk && o.json() {
+		log.Fatal("Can't use JSON output when asking")
+	}
+
+	base := o.load(files[0])
+	other := o.load(files[1])
+
+	in := bufio.NewReader(os.Stdin)
+	result, err := pwsafe.Merge(&base, &other, pwsafe.MergeOptions{
+		Winner: w,
+		Ask: func(c *pwsafe.MergeConflict) (bool, error) {
+			return askWinner(in, c, files[0], files[1])
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	base.Passwords = result.Passwords
+
+	if o.json() {
+		type conflict struct {
+			pwsafe.ReportRecord
+			Kept   string            `json:"kept"`
+			Fields []jsonFieldChange `json:"fields"`
+		}
+		out := struct {
+			Added     []pwsafe.ReportRecord `json:"added"`
+			Conflicts []conflict            `json:"conflicts"`
+			Total     int                   `json:"total"`
+		}{[]pwsafe.ReportRecord{}, []conflict{}, len(base.Passwords)}
+		for _, p := range result.Added {
+			out.Added = append(out.Added, pwsafe.NewReportRecord(&p))
+		}
+		for _, c := range result.Conflicts {
+			jc := conflict{ReportRecord: pwsafe.NewReportRecord(&c.Kept), Kept: files[0]}
+			if !c.LeftWon {
+				jc.Kept = files[1]
+			}
+			for _, f := range c.Fields {
+				jc.Fields = append(jc.Fields, jsonFieldChange{pwsafe.FieldName(f.Type), f.Secret, f.Old, f.New})
+			}
+			out.Conflicts = append(out.Conflicts, jc)
+		}
+		printJSON(out)
+	} else {
+		for _, c := range result.Conflicts {
+			kept := files[0]
+			if !c.LeftWon {
+				kept = files[1]
+			}
+			fmt.Printf("Conflict on %s, kept %s\n", describe(&c.Kept), kept)
+		}
+		fmt.Printf("Added %d records, %d in total\n", len(result.Added), len(base.Passwords))
+	}
+	o.write(files[2], &base)
+}
+
+// askWinner shows how the records differ and asks which to keep.
+func askWinner(in *bufio.Reader, c *pwsafe.MergeConflict, left, right string) (bool, error) {
+	fmt.Printf("\n%s differs:\n", describe(&c.Left))
+	for _, f := range c.Fields {
+		fmt.Printf("    %s\n", f)
+	}
+	for {
+		fmt.Printf("Keep (l)eft %s, (r)ight %s or (q)uit? ", left, right)
+		answer, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.TrimSpace(answer) {
+		case "l":
+			return true, nil
+		case "r":
+			return false, nil
+		case "q":
+			return false, errors.New("merge abandoned, nothing written")
+		}
+		if err == io.EOF {
+			return false, errors.New("merge abandoned, nothing written")
+		}
+	}
+}
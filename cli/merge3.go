@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// merge3 merges the changes made to two copies of a safe since their common
+// ancestor.  Like git merge-file it exits with 1 when there are conflicts.
+func merge3(args []string) {
+	o := newOptions("merge3", "ancestor.psafe3 ours.psafe3 theirs.psafe3 out.psafe3")
+	conflictEntries := o.flags.Bool("conflict-entries", false, "Add their version of records that conflict as \"-merged\" copies")
+	files := o.parse(args, 4)
+
+	result := threeWayMerge(o, files[0], files[1], files[2], files[3], *conflictEntries)
+	if len(result.Conflicts) > 0 {
+		os.Exit(1)
+	}
+}
+
+// threeWayMerge merges the safes, writes the result and reports the
+// conflicts.
+func threeWayMerge(o *options, ancestorFile, oursFile, theirsFile, out string, conflictEntries bool) pwsafe.Merge3Result {
+	ancestor := o.load(ancestorFile)
+	ours := o.load(oursFile)
+	theirs := o.load(theirsFile)
+
+	result := pwsafe.Merge3(&ancestor, &ours, &theirs, pwsafe.Merge3Options{ConflictEntries: conflictEntries})
+	ours.Passwords = result.Passwords
+
+	if o.json() {
+		type conflict struct {
+			pwsafe.ReportRecord
+			Reason string               `json:"reason"`
+			Fields []string             `json:"fields"`
+			Copy   *pwsafe.ReportRecord `json:"copy,omitempty"`
+		}
+		out := struct {
+			Conflicts []conflict `json:"conflicts"`
+			Total     int        `json:"total"`
+		}{[]conflict{}, len(result.Passwords)}
+		for _, c := range result.Conflicts {
+			jc := conflict{ReportRecord: pwsafe.NewReportRecord(&c.Record), Reason: c.Reason, Fields: []string{}}
+			for _, f := range c.Fields {
+				jc.Fields = append(jc.Fields, f.String())
+			}
+			if c.Copy != nil {
+				r := pwsafe.NewReportRecord(c.Copy)
+				jc.Copy = &r
+			}
+			out.Conflicts = append(out.Conflicts, jc)
+		}
+		printJSON(out)
+	} else {
+		for _, c := range result.Conflicts {
+			fmt.Printf("Conflict on %s, %s\n", describe(&c.Record), c.Reason)
+			for _, f := range c.Fields {
+				fmt.Printf("    %s\n", f)
+			}
+			if c.Copy != nil {
+				fmt.Printf("    their version added as %q (%s)\n", c.Copy.Text(pwsafe.Title), recordUUID(c.Copy))
+			}
+		}
+		fmt.Printf("%d conflicts, %d records\n", len(result.Conflicts), len(result.Passwords))
+	}
+	o.write(out, &ours)
+	return result
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// the fields shown side by side, in order.
+var nearDupFields = []byte{
+	pwsafe.Group,
+	pwsafe.Title,
+	pwsafe.Username,
+	pwsafe.Password,
+	pwsafe.URL,
+	pwsafe.Notes,
+	pwsafe.UUID,
+}
+
+func nearDups(args []string) {
+	o := newOptions("near-dups", "file.psafe3")
+	threshold := o.flags.Float64("threshold", pwsafe.DefaultNearDuplicateThreshold, "Similarity score (0-1) needed to count as a near duplicate")
+	showPasswords := o.flags.Bool("show-passwords", false, "Show passwords and other secrets rather than hiding them")
+	files := o.parse(args, 1)
+
+	pwFile := o.load(files[0])
+
+	groups := pwsafe.FindNearDuplicates(pwFile.Passwords, *threshold)
+	if o.json() {
+		type group struct {
+			Score   float64               `json:"score"`
+			Records []pwsafe.ReportRecord `json:"records"`
+		}
+		out := []group{}
+		for _, g := range groups {
+			jg := group{Score: g.Score}
+			for _, r := range g.Records {
+				jg.Records = append(jg.Records, pwsafe.NewReportRecord(&r))
+			}
+			out = append(out, jg)
+		}
+		printJSON(out)
+		return
+	}
+	for _, g := range groups {
+		fmt.Printf("== %d near duplicates, score %.2f ==\n", len(g.Records), g.Score)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, k := range nearDupFields {
+			row := []string{pwsafe.FieldName(k)}
+			for _, r := range g.Records {
+				row = append(row, sideBySideValue(&r, k, *showPasswords))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+		fmt.Println("")
+	}
+	fmt.Printf("%d groups of near duplicates\n", len(groups))
+}
+
+func sideBySideValue(r *pwsafe.PasswordRecord, typeID byte, showSecrets bool) string {
+	if _, ok := r.Fields[typeID]; !ok {
+		return "-"
+	}
+	if !showSecrets && (pwsafe.Redaction{}).Hidden(typeID) {
+		return pwsafe.Masked
+	}
+	v := r.Display(typeID, time.DateTime)
+	v = strings.ReplaceAll(strings.ReplaceAll(v, "\r", ""), "\n", " ")
+	if len([]rune(v)) > 30 {
+		v = string([]rune(v)[:29]) + "…"
+	}
+	return v
+}
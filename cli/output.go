@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func printJSON(v interface{}) {
+	if err := writeJSON(os.Stdout, v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+// parseColumns parses a comma separated list of fields, returning the names
+// as given and the field types.  Secret fields are refused unless allowed.
+func parseColumns(columns string, secrets bool) ([]string, []byte, error) {
+	var names []string
+	var types []byte
+	for _, name := range strings.Split(columns, ",") {
+		t, err := pwsafe.ParseFieldName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !secrets && slices.Contains(pwsafe.SecretFields, t) {
+			return nil, nil, fmt.Errorf("%s is secret", pwsafe.FieldName(t))
+		}
+		names = append(names, strings.ToLower(strings.TrimSpace(name)))
+		types = append(types, t)
+	}
+	return names, types, nil
+}
+
+// recordRows gives the chosen fields of each record keyed by the names
+// given, for JSON output.
+func recordRows(passwords []pwsafe.PasswordRecord, names []string, types []byte) []map[string]string {
+	rows := []map[string]string{}
+	for _, p := range passwords {
+		row := make(map[string]string)
+		for i, t := range types {
+			row[names[i]] = p.Display(t, time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// recordMap gives the fields of a record by name, with the hidden secrets
+// masked, so it can be output as JSON.
+func recordMap(p *pwsafe.PasswordRecord, r pwsafe.Redaction) map[string]interface{} {
+	m := make(map[string]interface{})
+	redacted := r.Redact(*p)
+	for k, f := range redacted.Fields {
+		m[pwsafe.FieldName(k)] = f.Data
+		if k == pwsafe.TwoFactorKey {
+			m[pwsafe.FieldName(k)] = redacted.Display(k, "")
+		}
+	}
+	return m
+}
+
+type jsonHeader struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// headerList gives the headers by name.  Some can appear more than once so
+// they stay as a list.
+func headerList(headers []pwsafe.HeaderRecord) []jsonHeader {
+	list := []jsonHeader{}
+	for _, h := range headers {
+		list = append(list, jsonHeader{Name: pwsafe.HeaderName(h.Type), Value: h.Data})
+	}
+	return list
+}
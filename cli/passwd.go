@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"log"
+)
+
+func passwd(args []string) {
+	o := newOptions("passwd", "in.psafe3 [out.psafe3]")
+	files := o.parse(args, 1)
+
+	pwFile := o.load(files[0])
+
+	password, err := o.passwordSource.prompt("New Password: ", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(password) == 0 {
+		log.Fatal("The password can't be empty")
+	}
+	confirm, err := o.passwordSource.prompt("Confirm New Password: ", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !bytes.Equal(password, confirm) {
+		log.Fatal("Passwords don't match")
+	}
+
+	out := files[0]
+	if len(files) > 1 {
+		out = files[1]
+	}
+	write(out, &pwFile, password)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/colinnewell/pwsafe-de-dup/pinentry"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passwordSource is where the master password comes from when it isn't
+// typed in.  The password itself is never accepted as an argument because
+// anyone on the machine could see it with ps.
+type passwordSource struct {
+	file     string
+	fd       int
+	env      string
+	command  string
+	pinentry string
+}
+
+func (s *passwordSource) addFlags(o *options) {
+	o.flags.StringVar(&s.file, "password-file", "", "Read the password from the first line of this file")
+	o.flags.IntVar(&s.fd, "password-fd", -1, "Read the password from the first line of this file descriptor")
+	o.flags.StringVar(&s.env, "password-env", "", "Read the password from this environment variable")
+	o.flags.StringVar(&s.command, "password-command", "", "Run this command with the shell and read the password from the first line it prints")
+	o.flags.StringVar(&s.pinentry, "pinentry", "", "Ask for the password with this pinentry program, e.g. pinentry-curses")
+}
+
+// interactive is true when the password will be typed in, so it can be asked
+// for again.
+func (s *passwordSource) interactive() bool {
+	return s.file == "" && s.fd < 0 && s.env == "" && s.command == ""
+}
+
+func (s *passwordSource) check() error {
+	n := 0
+	for _, set := range []bool{s.file != "", s.fd >= 0, s.env != "", s.command != "", s.pinentry != ""} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("only one of -password-file, -password-fd, -password-env, -password-command and -pinentry can be used")
+	}
+	return nil
+}
+
+// read gets the password from the chosen source, or prompts for it.
+func (s *passwordSource) read(prompt string) ([]byte, error) {
+	switch {
+	case s.file != "":
+		f, err := os.Open(s.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return firstLine(f)
+	case s.fd >= 0:
+		f := os.Stdin
+		if s.fd != 0 {
+			// stdin is left open as other things may read it
+			f = os.NewFile(uintptr(s.fd), fmt.Sprintf("fd %d", s.fd))
+			defer f.Close()
+		}
+		// reading it shows whether it's a valid descriptor
+		password, err := firstLine(f)
+		if err != nil {
+			return nil, fmt.Errorf("password fd %d: %w", s.fd, err)
+		}
+		return password, nil
+	case s.env != "":
+		password, ok := os.LookupEnv(s.env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s not set", s.env)
+		}
+		// don't pass it on to anything we run
+		os.Unsetenv(s.env)
+		return []byte(password), nil
+	case s.command != "":
+		cmd := exec.Command("sh", "-c", s.command)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("password command failed: %w", err)
+		}
+		return firstLine(bytes.NewReader(out))
+	}
+	return s.prompt(prompt, "")
+}
+
+// prompt asks for a password on the terminal, or with pinentry if it's been
+// chosen.  The problem is shown when asking again after a bad password.
+func (s *passwordSource) prompt(prompt, problem string) ([]byte, error) {
+	if s.pinentry == "" {
+		if problem != "" {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return readPassword(prompt), nil
+	}
+	p := pinentry.Pinentry{
+		Path:        s.pinentry,
+		TTY:         os.Getenv("GPG_TTY"),
+		Title:       "pwsafe",
+		Description: strings.TrimSuffix(prompt, ": "),
+		Prompt:      "Password:",
+		Error:       problem,
+	}
+	if p.TTY == "" {
+		// curses pinentry talks to us over pipes, so it needs telling which
+		// terminal to use
+		p.TTY = ttyName()
+	}
+	return p.GetPIN()
+}
+
+// ttyName is the name of the terminal on stdin, or the controlling terminal
+// if stdin has been redirected.
+func ttyName() string {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		if name, err := os.Readlink("/proc/self/fd/0"); err == nil {
+			return name
+		}
+	}
+	return "/dev/tty"
+}
+
+func firstLine(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) == 0 {
+		return nil, fmt.Errorf("no password found")
+	}
+	return line, nil
+}
+
+// readPassword reads a password without echoing it.  The prompt goes to
+// stderr so stdout can be redirected.
+func readPassword(prompt string) []byte {
+	fmt.Fprint(os.Stderr, prompt)
+	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return bytePassword
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFirstLine(t *testing.T) {
+	for in, expected := range map[string]string{
+		"secret":               "secret",
+		"secret\n":             "secret",
+		"secret\r\nsecond\n":   "secret",
+		"with spaces \nsecond": "with spaces ",
+	} {
+		got, err := firstLine(strings.NewReader(in))
+		if err != nil {
+			t.Errorf("%q: %s", in, err)
+			continue
+		}
+		if string(got) != expected {
+			t.Errorf("Expected %q from %q, got %q", expected, in, got)
+		}
+	}
+	for _, in := range []string{"", "\n", "\r\nsecret"} {
+		if _, err := firstLine(strings.NewReader(in)); err == nil {
+			t.Errorf("Expected an error for %q", in)
+		}
+	}
+}
+
+func TestPasswordSourceCheck(t *testing.T) {
+	for _, s := range []passwordSource{
+		{fd: -1},
+		{fd: -1, file: "password.txt"},
+		{fd: 0},
+		{fd: -1, env: "PASSWORD"},
+		{fd: -1, command: "pass show safe"},
+		{fd: -1, pinentry: "pinentry-curses"},
+	} {
+		if err := s.check(); err != nil {
+			t.Errorf("%+v: %s", s, err)
+		}
+	}
+	for _, s := range []passwordSource{
+		{fd: 3, file: "password.txt"},
+		{fd: -1, env: "PASSWORD", command: "pass show safe"},
+		{fd: -1, file: "password.txt", pinentry: "pinentry-curses"},
+	} {
+		if err := s.check(); err == nil {
+			t.Errorf("Expected an error for %+v", s)
+		}
+	}
+	if !(&passwordSource{fd: -1, pinentry: "pinentry-curses"}).interactive() {
+		t.Error("Expected pinentry to be interactive")
+	}
+	if (&passwordSource{fd: 0}).interactive() {
+		t.Error("Expected a file descriptor not to be interactive")
+	}
+}
+
+func TestPasswordSourceRead(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/password"
+	if err := os.WriteFile(file, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PWSAFE_TEST_PASSWORD", "from env")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := w.WriteString("from fd\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	// the source closes the descriptor it's given
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for expected, s := range map[string]passwordSource{
+		"from file":    {fd: -1, file: file},
+		"from env":     {fd: -1, env: "PWSAFE_TEST_PASSWORD"},
+		"from fd":      {fd: fd},
+		"from command": {fd: -1, command: "echo from command"},
+	} {
+		got, err := s.read("Password: ")
+		if err != nil {
+			t.Errorf("%+v: %s", s, err)
+			continue
+		}
+		if string(got) != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+	if _, ok := os.LookupEnv("PWSAFE_TEST_PASSWORD"); ok {
+		t.Error("Expected the environment variable to be removed")
+	}
+
+	for _, s := range []passwordSource{
+		{fd: -1, file: dir + "/missing"},
+		{fd: -1, env: "PWSAFE_TEST_MISSING"},
+		{fd: 1000},
+		{fd: -1, command: "exit 1"},
+	} {
+		if _, err := s.read("Password: "); err == nil {
+			t.Errorf("Expected an error for %+v", s)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	highlight = "\x1b[1;31m"
+	reset     = "\x1b[0m"
+)
+
+type reviewer struct {
+	in          *bufio.Reader
+	out         io.Writer
+	color       bool
+	showSecrets bool
+}
+
+// newReviewer asks the questions on out and reads the answers from in.
+// Differences are highlighted when out is a terminal.
+func newReviewer(in io.Reader, out io.Writer) *reviewer {
+	r := &reviewer{in: bufio.NewReader(in), out: out}
+	if f, ok := out.(*os.File); ok {
+		r.color = terminal.IsTerminal(int(f.Fd()))
+	}
+	return r
+}
+
+// review steps through the groups of near duplicates asking the user what to
+// do with each.  It returns the passwords with the user's decisions applied,
+// and the groups that were changed.  ok is false if the user chose not to
+// write the changes.
+func (r *reviewer) review(passwords []pwsafe.PasswordRecord, threshold float64) (result pwsafe.DedupResult, ok bool) {
+	drop := make(map[int]bool)
+	replace := make(map[int]pwsafe.PasswordRecord)
+	groups := pwsafe.FindNearDuplicates(passwords, threshold)
+	for i, g := range groups {
+		group, quit := r.decide(&g, i+1, len(groups))
+		if quit {
+			break
+		}
+		if group == nil {
+			continue
+		}
+		for n, index := range g.Indexes {
+			if n != group.Kept {
+				drop[index] = true
+				result.Removed = append(result.Removed, g.Records[n])
+			}
+		}
+		if group.Merged {
+			replace[g.Indexes[group.Kept]] = r.merged(&g)
+		}
+		result.Groups = append(result.Groups, *group)
+	}
+
+	for i, p := range passwords {
+		if drop[i] {
+			continue
+		}
+		if m, ok := replace[i]; ok {
+			p = m
+		}
+		result.Passwords = append(result.Passwords, p)
+	}
+
+	fmt.Fprintf(r.out, "%d groups changed, %d records removed.  Write changes? [y/N] ", len(result.Groups), len(result.Removed))
+	answer, err := r.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	return result, strings.EqualFold(strings.TrimSpace(answer), "y")
+}
+
+// decide shows the group and asks what to do.  It returns nil if all the
+// records should be kept.
+func (r *reviewer) decide(g *pwsafe.NearDuplicates, n, total int) (*pwsafe.DuplicateGroup, bool) {
+	for {
+		fmt.Fprintf(r.out, "\n== Group %d of %d, %d records, score %.2f ==\n", n, total, len(g.Records), g.Score)
+		r.show(g)
+		fmt.Fprintf(r.out, "[1-%d] keep one, (a)ll, (m)erge, (p)asswords %s, (q)uit: ",
+			len(g.Records), map[bool]string{true: "hide", false: "show"}[r.showSecrets])
+		answer, err := r.in.ReadString('\n')
+		if err == io.EOF {
+			return nil, true
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		answer = strings.TrimSpace(answer)
+		switch answer {
+		case "a":
+			return nil, false
+		case "m":
+			_, conflicts := pwsafe.MergeRecords(g.Records, 0, pwsafe.DefaultNotesSeparator)
+			if len(conflicts) > 0 {
+				var names []string
+				for _, c := range conflicts {
+					names = append(names, pwsafe.FieldName(c))
+				}
+				fmt.Fprintf(r.out, "Can't merge, %s differs\n", strings.Join(names, " and "))
+				continue
+			}
+			return &pwsafe.DuplicateGroup{Records: g.Records, Merged: true, Reason: "merged by hand"}, false
+		case "p":
+			r.showSecrets = !r.showSecrets
+			continue
+		case "q":
+			return nil, true
+		}
+		if k, err := strconv.Atoi(answer); err == nil && k >= 1 && k <= len(g.Records) {
+			return &pwsafe.DuplicateGroup{Records: g.Records, Kept: k - 1, Reason: "chosen by hand"}, false
+		}
+		fmt.Fprintf(r.out, "Unknown choice %q\n", answer)
+	}
+}
+
+func (r *reviewer) merged(g *pwsafe.NearDuplicates) pwsafe.PasswordRecord {
+	m, _ := pwsafe.MergeRecords(g.Records, 0, pwsafe.DefaultNotesSeparator)
+	return m
+}
+
+// show prints the records side by side, field by field, marking the fields
+// that differ.
+func (r *reviewer) show(g *pwsafe.NearDuplicates) {
+	types := make(map[byte]bool)
+	for _, p := range g.Records {
+		for k := range p.Fields {
+			types[k] = true
+		}
+	}
+	var keys []byte
+	for k := range types {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	header := []string{" ", ""}
+	for i := range g.Records {
+		header = append(header, fmt.Sprintf("[%d]", i+1))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	var differs []bool
+	for _, k := range keys {
+		row := []string{" ", pwsafe.FieldName(k)}
+		different := false
+		for i := range g.Records {
+			a, b := g.Records[0].Fields[k], g.Records[i].Fields[k]
+			if a.String() != b.String() {
+				different = true
+			}
+			row = append(row, sideBySideValue(&g.Records[i], k, r.showSecrets))
+		}
+		if different {
+			row[0] = "*"
+		}
+		differs = append(differs, different)
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 && differs[i-1] && r.color {
+			line = highlight + line + reset
+		}
+		fmt.Fprintln(r.out, line)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func login(title, user, password string) pwsafe.PasswordRecord {
+	r := record(title)
+	r.Fields[pwsafe.Username] = pwsafe.Field{Type: pwsafe.Username, Data: user}
+	r.Fields[pwsafe.Password] = pwsafe.Field{Type: pwsafe.Password, Data: password}
+	return r
+}
+
+func TestReview(t *testing.T) {
+	a := login("bank", "user", "hunter2")
+	b := login("bank", "user", "hunter2")
+	b.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "notes"}
+	c := login("shop", "someone", "pass")
+	d := login("shop", "someone", "pass")
+	other := login("unrelated", "nobody", "different")
+	passwords := []pwsafe.PasswordRecord{a, b, c, other, d}
+
+	// an unknown answer is asked again, then keep the second of the first
+	// group and merge the second
+	var out bytes.Buffer
+	r := newReviewer(strings.NewReader("x\n2\nm\ny\n"), &out)
+	result, ok := r.review(passwords, pwsafe.DefaultNearDuplicateThreshold)
+
+	if !ok {
+		t.Fatal("Expected the changes to be confirmed")
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Error("Expected the passwords to be hidden")
+	}
+	for _, want := range []string{"Group 1 of 2", "Unknown choice \"x\"", "2 groups changed, 2 records removed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{b, c, other}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, d}, result.Removed); diff != "" {
+		t.Errorf("Unexpected removed (-want +got):\n%s\n", diff)
+	}
+	if len(result.Groups) != 2 || result.Groups[0].Kept != 1 || !result.Groups[1].Merged {
+		t.Errorf("Unexpected groups %+v", result.Groups)
+	}
+}
+
+func TestReviewQuit(t *testing.T) {
+	passwords := []pwsafe.PasswordRecord{login("bank", "user", "hunter2"), login("bank", "user", "hunter2")}
+
+	var out bytes.Buffer
+	r := newReviewer(strings.NewReader("p\nq\n\n"), &out)
+	result, ok := r.review(passwords, pwsafe.DefaultNearDuplicateThreshold)
+
+	if ok {
+		t.Error("Expected nothing to be written")
+	}
+	if !strings.Contains(out.String(), "hunter2") {
+		t.Error("Expected p to show the passwords")
+	}
+	if diff := cmp.Diff(passwords, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestReviewMergeConflict(t *testing.T) {
+	passwords := []pwsafe.PasswordRecord{login("bank", "user", "hunter2"), login("bank", "user", "hunter3")}
+
+	var out bytes.Buffer
+	r := newReviewer(strings.NewReader("m\na\ny\n"), &out)
+	result, ok := r.review(passwords, 0.5)
+
+	if !ok {
+		t.Fatal("Expected the changes to be confirmed")
+	}
+	if !strings.Contains(out.String(), "Can't merge, Password differs") {
+		t.Errorf("Expected the merge to be refused:\n%s", out.String())
+	}
+	if len(result.Groups) != 0 || len(result.Passwords) != 2 {
+		t.Errorf("Expected both kept, got %d groups %d records", len(result.Groups), len(result.Passwords))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"slices"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func show(args []string) {
+	o := newOptions("show", "file.psafe3 uuid|title")
+	reveal := o.flags.String("reveal", "", "Comma separated secret fields to show, e.g. password,totp, or all")
+	files := o.parse(args, 2)
+
+	r, err := parseReveal(*reveal)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pwFile := o.load(files[0])
+
+	var found []pwsafe.PasswordRecord
+	for _, p := range pwFile.Passwords {
+		if recordUUID(&p) == files[1] || p.Text(pwsafe.Title) == files[1] {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 {
+		log.Fatalf("No record matching %q", files[1])
+	}
+	printRecords(o, found, r)
+}
+
+func parseReveal(reveal string) (pwsafe.Redaction, error) {
+	if reveal == "all" {
+		return pwsafe.RevealAll, nil
+	}
+	types, err := pwsafe.ParseFieldNames(reveal)
+	if err != nil {
+		return pwsafe.Redaction{}, err
+	}
+	for _, t := range types {
+		if !slices.Contains(pwsafe.SecretFields, t) {
+			log.Printf("%s isn't secret, it's always shown", pwsafe.FieldName(t))
+		}
+	}
+	return pwsafe.Redaction{Reveal: types}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// exit statuses for verify, the worst one across the files is used.  A file
+// that can't be read at all is the worst, so a missing backup isn't hidden
+// by warnings about another file.
+const (
+	exitWarnings    = 3
+	exitBadPassword = 4
+	exitCorrupt     = 5
+	exitUnreadable  = 6
+)
+
+type verifyResult struct {
+	File     string   `json:"file"`
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings"`
+	Headers  int      `json:"headers"`
+	Records  int      `json:"records"`
+
+	status int
+}
+
+func verify(args []string) {
+	o := newOptions("verify", "file.psafe3...")
+	files := o.parse(args, 1)
+
+	password, err := o.passwordSource.read("Enter Password: ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := []verifyResult{}
+	status := 0
+	for _, filename := range files {
+		r := verifyFile(filename, password)
+		results = append(results, r)
+		status = max(status, r.status)
+	}
+
+	if o.json() {
+		printJSON(results)
+	} else {
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				fmt.Printf("%s: %s\n", r.File, r.Error)
+			case len(r.Warnings) > 0:
+				fmt.Printf("%s: %d warnings, %d headers, %d records\n", r.File, len(r.Warnings), r.Headers, r.Records)
+				for _, w := range r.Warnings {
+					fmt.Printf("  %s\n", w)
+				}
+			default:
+				fmt.Printf("%s: OK, %d headers, %d records\n", r.File, r.Headers, r.Records)
+			}
+		}
+	}
+	os.Exit(status)
+}
+
+// verifyFile checks the tag, the iterations, the password, the structure of
+// the records and the HMAC without writing anything.
+func verifyFile(filename string, password []byte) verifyResult {
+	r := verifyResult{File: filename, Warnings: []string{}}
+	file, err := os.Open(filename)
+	if err != nil {
+		r.Error = err.Error()
+		r.status = exitUnreadable
+		return r
+	}
+	defer file.Close()
+
+	pwFile, warnings, err := pwsafe.Verify(file, password)
+	switch {
+	case errors.Is(err, pwsafe.ErrIncorrectPassword):
+		r.status = exitBadPassword
+	case errors.Is(err, pwsafe.ErrCorrupt):
+		r.status = exitCorrupt
+	case err != nil:
+		r.status = exitUnreadable
+	case len(warnings) > 0:
+		r.status = exitWarnings
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.OK = len(warnings) == 0
+	r.Warnings = append(r.Warnings, warnings...)
+	r.Headers = len(pwFile.Headers)
+	r.Records = len(pwFile.Passwords)
+	return r
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	password := []byte("test password")
+	r := record("a")
+	r.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: strings.Repeat("notes ", 500)}
+	good := filepath.Join(dir, "good.psafe3")
+	v3 := pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.13"}},
+		Passwords: []pwsafe.PasswordRecord{r},
+	}
+	if err := writeFile(good, &v3, password); err != nil {
+		t.Fatal(err)
+	}
+	// warnings as the first header isn't the version
+	warned := filepath.Join(dir, "warned.psafe3")
+	v3.Headers = nil
+	if err := writeFile(warned, &v3, password); err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, expected := range map[string]int{
+		good:                                 0,
+		warned:                               exitWarnings,
+		filepath.Join(dir, "missing.psafe3"): exitUnreadable,
+	} {
+		if got := verifyFile(filename, password).status; got != expected {
+			t.Errorf("%s: expected status %d, got %d", filepath.Base(filename), expected, got)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package pwsafe
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// KeepPolicy decides which record of a duplicate group survives.
+type KeepPolicy int
+
+const (
+	KeepFirst KeepPolicy = iota
+	KeepOldest
+	KeepNewest
+	KeepLastUsed
+	KeepMostFields
+)
+
+var keepPolicyNames = []string{
+	KeepFirst:      "first",
+	KeepOldest:     "oldest",
+	KeepNewest:     "newest",
+	KeepLastUsed:   "last-used",
+	KeepMostFields: "most-fields",
+}
+
+func (k KeepPolicy) String() string {
+	if k < 0 || int(k) >= len(keepPolicyNames) {
+		return fmt.Sprintf("KeepPolicy(%d)", int(k))
+	}
+	return keepPolicyNames[k]
+}
+
+// KeepPolicyNames lists the names accepted by ParseKeepPolicy.
+func KeepPolicyNames() []string {
+	return append([]string(nil), keepPolicyNames...)
+}
+
+func ParseKeepPolicy(name string) (KeepPolicy, error) {
+	for i, n := range keepPolicyNames {
+		if n == name {
+			return KeepPolicy(i), nil
+		}
+	}
+	return KeepFirst, fmt.Errorf("unknown keep policy %q, expected one of %s",
+		name, strings.Join(keepPolicyNames, ", "))
+}
+
+// Match decides which records count as duplicates.
+type Match int
+
+const (
+	// MatchExact requires the records to be the same apart from their UUIDs
+	// and times, so copies made at different times still match.
+	MatchExact Match = iota
+	// MatchEntry requires the same Group, Title and URL.
+	MatchEntry
+)
+
+var matchNames = []string{
+	MatchExact: "exact",
+	MatchEntry: "entry",
+}
+
+func (m Match) String() string {
+	if m < 0 || int(m) >= len(matchNames) {
+		return fmt.Sprintf("Match(%d)", int(m))
+	}
+	return matchNames[m]
+}
+
+// MatchNames lists the names accepted by ParseMatch.
+func MatchNames() []string {
+	return append([]string(nil), matchNames...)
+}
+
+func ParseMatch(name string) (Match, error) {
+	for i, n := range matchNames {
+		if n == name {
+			return Match(i), nil
+		}
+	}
+	return MatchExact, fmt.Errorf("unknown match %q, expected one of %s",
+		name, strings.Join(matchNames, ", "))
+}
+
+// DefaultNotesSeparator goes between differing notes when records are
+// merged.
+const DefaultNotesSeparator = "\r\n----\r\n"
+
+type DedupOptions struct {
+	Keep  KeepPolicy
+	Match Match
+	// Merge combines the fields of the duplicates into the kept record
+	// rather than dropping them.
+	Merge bool
+	// defaults to DefaultNotesSeparator
+	NotesSeparator string
+}
+
+// DuplicateGroup is a set of records that were considered the same.
+type DuplicateGroup struct {
+	Records []PasswordRecord
+	// index into Records of the one that was kept
+	Kept int
+	// true when the other records were merged into the kept one
+	Merged bool
+	// field types that differ in a way that can't be merged.  When there
+	// are conflicts all the records are kept for review.
+	Conflicts []byte
+	// why the record was kept when it wasn't down to the keep policy
+	Reason string
+}
+
+// KeptRecord returns the record that survived.
+func (g *DuplicateGroup) KeptRecord() *PasswordRecord {
+	return &g.Records[g.Kept]
+}
+
+// KeptUUID returns the UUID of the record that survived, or uuid.Nil if it
+// doesn't have one.
+func (g *DuplicateGroup) KeptUUID() uuid.UUID {
+	u, _ := g.KeptRecord().ID()
+	return u
+}
+
+type DedupResult struct {
+	// the records to write out, in the order they were first seen
+	Passwords []PasswordRecord
+	// only groups with more than one record
+	Groups []DuplicateGroup
+	// the records that were dropped
+	Removed []PasswordRecord
+}
+
+// Dedup collapses records that match into one, choosing the survivor
+// according to the keep policy.
+func Dedup(passwords []PasswordRecord, opts DedupOptions) DedupResult {
+	var order []string
+	groups := make(map[string][]PasswordRecord)
+	for _, p := range passwords {
+		key := matchKey(&p, opts.Match)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+
+	separator := opts.NotesSeparator
+	if separator == "" {
+		separator = DefaultNotesSeparator
+	}
+
+	var result DedupResult
+	for _, key := range order {
+		records := groups[key]
+		kept := choose(records, opts.Keep)
+		if len(records) < 2 {
+			result.Passwords = append(result.Passwords, records[kept])
+			continue
+		}
+		group := DuplicateGroup{Records: records, Kept: kept}
+		survivor := records[kept]
+		if opts.Merge {
+			merged, conflicts := MergeRecords(records, kept, separator)
+			if len(conflicts) > 0 {
+				group.Conflicts = conflicts
+				result.Passwords = append(result.Passwords, records...)
+				result.Groups = append(result.Groups, group)
+				continue
+			}
+			group.Merged = true
+			survivor = merged
+		}
+		result.Passwords = append(result.Passwords, survivor)
+		for i := range records {
+			if i != kept {
+				result.Removed = append(result.Removed, records[i])
+			}
+		}
+		result.Groups = append(result.Groups, group)
+	}
+	return result
+}
+
+func matchKey(p *PasswordRecord, match Match) string {
+	if match == MatchEntry {
+		// NUL can't appear in the fields so it's safe as a separator
+		return strings.Join([]string{p.Text(Group), p.Text(Title), p.Text(URL)}, "\x00")
+	}
+	// the times are what the keep policies choose between
+	r := NewPasswordRecord()
+	for k, v := range p.Fields {
+		switch k {
+		case UUID, CreationTime, PasswordModificationTime, LastAccessTime, LastModificationTime:
+		default:
+			r.Fields[k] = v
+		}
+	}
+	sha := r.Sha256()
+	return string(sha[:])
+}
+
+// credentialKey is the same for records with the same password, and the
+// same username and site once they're normalised as they are for near
+// duplicates.  The site is the host of the URL, or the title when there's no
+// URL, so records are found again whatever their UUIDs and however they were
+// exported.  Records without a username or password, like notes, have to
+// match exactly.
+func credentialKey(p *PasswordRecord) string {
+	user, password := Normalise(Username, p.Text(Username)), p.Text(Password)
+	if user == "" && password == "" {
+		return matchKey(p, MatchExact)
+	}
+	site := Normalise(URL, p.Text(URL))
+	site, _, _ = strings.Cut(site, "/")
+	site = strings.TrimPrefix(site, "www.")
+	if site == "" {
+		site = strings.ToLower(Normalise(Title, p.Text(Title)))
+	}
+	return strings.Join([]string{user, site, password}, "\x00")
+}
+
+// NewCredentials splits the imported records into those whose credentials
+// aren't in the safe yet and those that are, either in the existing records
+// or earlier in the import.
+func NewCredentials(existing, imported []PasswordRecord) (added, skipped []PasswordRecord) {
+	seen := make(map[string]bool)
+	for _, p := range existing {
+		seen[credentialKey(&p)] = true
+	}
+	for _, p := range imported {
+		key := credentialKey(&p)
+		if seen[key] {
+			skipped = append(skipped, p)
+			continue
+		}
+		seen[key] = true
+		added = append(added, p)
+	}
+	return added, skipped
+}
+
+// MergeRecords combines records into a copy of records[base].  Fields
+// missing from the base are filled in from the others, password histories
+// are unioned, the earliest creation time and the latest modification and
+// access times are used, and differing notes are joined with the separator.
+// Differences in Password or Username are returned as conflicts and
+// otherwise the base record's value wins.
+func MergeRecords(records []PasswordRecord, base int, notesSeparator string) (PasswordRecord, []byte) {
+	merged := NewPasswordRecord()
+	for k, v := range records[base].Fields {
+		merged.Fields[k] = v
+	}
+	notes := []string{merged.Text(Notes)}
+	var conflicts []byte
+	for i := range records {
+		if i == base {
+			continue
+		}
+		r := &records[i]
+		for _, k := range r.sortedFieldKey() {
+			f := r.Fields[k]
+			existing, ok := merged.Fields[k]
+			if !ok {
+				merged.Fields[k] = f
+				if k == Notes {
+					notes = append(notes, r.Text(Notes))
+				}
+				continue
+			}
+			switch k {
+			case Password, Username:
+				if existing.String() != f.String() && !slices.Contains(conflicts, k) {
+					conflicts = append(conflicts, k)
+				}
+			case CreationTime:
+				if t, ok := r.Time(k); ok && t < timeOrZero(&merged, k) {
+					merged.Fields[k] = f
+				}
+			case LastModificationTime, LastAccessTime:
+				if t, ok := r.Time(k); ok && t > timeOrZero(&merged, k) {
+					merged.Fields[k] = f
+				}
+			case PasswordHistory:
+				a, errA := ParseHistory(merged.Text(k))
+				b, errB := ParseHistory(r.Text(k))
+				if errA == nil && errB == nil {
+					merged.Fields[k] = Field{Type: k, Data: MergeHistory(a, b).String()}
+				}
+			case Notes:
+				n := r.Text(Notes)
+				if n != "" && !slices.Contains(notes, n) {
+					notes = append(notes, n)
+					merged.Fields[k] = Field{Type: k, Data: joinNotes(notes, notesSeparator)}
+				}
+			}
+		}
+	}
+	return merged, conflicts
+}
+
+func joinNotes(notes []string, separator string) string {
+	var nonEmpty []string
+	for _, n := range notes {
+		if n != "" {
+			nonEmpty = append(nonEmpty, n)
+		}
+	}
+	return strings.Join(nonEmpty, separator)
+}
+
+// timeOrZero is Time with 0 for a missing or broken field.
+func timeOrZero(p *PasswordRecord, typeID byte) uint32 {
+	t, _ := p.Time(typeID)
+	return t
+}
+
+// choose returns the index of the record to keep.  Ties go to the earliest
+// record.
+func choose(records []PasswordRecord, keep KeepPolicy) int {
+	best := 0
+	for i := 1; i < len(records); i++ {
+		if better(&records[i], &records[best], keep) {
+			best = i
+		}
+	}
+	return best
+}
+
+// decidedBy reports whether the keep policy preferred the kept record to
+// any of the others, rather than them all being equal.
+func (g *DuplicateGroup) decidedBy(keep KeepPolicy) bool {
+	for i := range g.Records {
+		if i != g.Kept && better(&g.Records[g.Kept], &g.Records[i], keep) {
+			return true
+		}
+	}
+	return false
+}
+
+func better(a, b *PasswordRecord, keep KeepPolicy) bool {
+	switch keep {
+	case KeepOldest:
+		at, aok := a.Time(CreationTime)
+		bt, bok := b.Time(CreationTime)
+		// a record with no creation time never wins
+		return aok && (!bok || at < bt)
+	case KeepNewest:
+		return newer(a, b, LastModificationTime)
+	case KeepLastUsed:
+		return newer(a, b, LastAccessTime)
+	case KeepMostFields:
+		return len(a.Fields) > len(b.Fields)
+	}
+	return false
+}
+
+func newer(a, b *PasswordRecord, typeID byte) bool {
+	at, aok := a.Time(typeID)
+	bt, bok := b.Time(typeID)
+	return aok && (!bok || at > bt)
+}
@@ -0,0 +1,230 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func record(u uuid.UUID, title, user, password string) pwsafe.PasswordRecord {
+	r := pwsafe.NewPasswordRecord()
+	r.Fields[pwsafe.UUID] = pwsafe.Field{Type: pwsafe.UUID, Data: u}
+	r.Fields[pwsafe.Title] = pwsafe.Field{Type: pwsafe.Title, Data: title}
+	r.Fields[pwsafe.Username] = pwsafe.Field{Type: pwsafe.Username, Data: user}
+	r.Fields[pwsafe.Password] = pwsafe.Field{Type: pwsafe.Password, Data: password}
+	return r
+}
+
+func TestDedup(t *testing.T) {
+	a := record(uuid.New(), "a", "user", "pass")
+	b := record(uuid.New(), "b", "user", "pass")
+	passwords := []pwsafe.PasswordRecord{a, b, a, a}
+
+	result := pwsafe.Dedup(passwords, pwsafe.DedupOptions{})
+
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, b}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+	if len(result.Removed) != 2 {
+		t.Errorf("Expected 2 removed, got %d", len(result.Removed))
+	}
+	if len(result.Groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(result.Groups))
+	}
+	g := result.Groups[0]
+	if len(g.Records) != 3 {
+		t.Errorf("Expected 3 records in group, got %d", len(g.Records))
+	}
+	id, _ := a.ID()
+	if g.KeptUUID() != id {
+		t.Errorf("Expected %s to be kept, got %s", id, g.KeptUUID())
+	}
+}
+
+func TestDedupKeepPolicy(t *testing.T) {
+	withTime := func(r pwsafe.PasswordRecord, typeID byte, t uint32) pwsafe.PasswordRecord {
+		r.Fields[typeID] = pwsafe.Field{Type: typeID, Data: t}
+		return r
+	}
+	first := record(uuid.New(), "title", "user", "pass")
+	first = withTime(first, pwsafe.CreationTime, 200)
+	first = withTime(first, pwsafe.LastModificationTime, 300)
+	old := record(uuid.New(), "title", "user", "pass")
+	old = withTime(old, pwsafe.CreationTime, 100)
+	recent := record(uuid.New(), "title", "user", "pass")
+	recent = withTime(recent, pwsafe.LastModificationTime, 400)
+	recent = withTime(recent, pwsafe.LastAccessTime, 100)
+	used := record(uuid.New(), "title", "user", "pass")
+	used = withTime(used, pwsafe.LastAccessTime, 500)
+	full := record(uuid.New(), "title", "user", "pass")
+	full.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "notes"}
+	full.Fields[pwsafe.EMailAddress] = pwsafe.Field{Type: pwsafe.EMailAddress, Data: "user@example.com"}
+	full.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "00000"}
+	passwords := []pwsafe.PasswordRecord{first, old, recent, used, full}
+
+	for keep, expected := range map[pwsafe.KeepPolicy]pwsafe.PasswordRecord{
+		pwsafe.KeepFirst:      first,
+		pwsafe.KeepOldest:     old,
+		pwsafe.KeepNewest:     recent,
+		pwsafe.KeepLastUsed:   used,
+		pwsafe.KeepMostFields: full,
+	} {
+		result := pwsafe.Dedup(passwords, pwsafe.DedupOptions{Keep: keep, Match: pwsafe.MatchEntry})
+		if diff := cmp.Diff([]pwsafe.PasswordRecord{expected}, result.Passwords); diff != "" {
+			t.Errorf("Unexpected record kept by %s (-want +got):\n%s\n", keep, diff)
+		}
+		if len(result.Removed) != 4 {
+			t.Errorf("Expected %s to remove 4, got %d", keep, len(result.Removed))
+		}
+	}
+}
+
+func TestDedupKeepPolicyExact(t *testing.T) {
+	withTime := func(r pwsafe.PasswordRecord, typeID byte, t uint32) pwsafe.PasswordRecord {
+		r.Fields[typeID] = pwsafe.Field{Type: typeID, Data: t}
+		return r
+	}
+	// copies of the same record made at different times
+	first := withTime(record(uuid.New(), "title", "user", "pass"), pwsafe.CreationTime, 200)
+	old := withTime(record(uuid.New(), "title", "user", "pass"), pwsafe.CreationTime, 100)
+	recent := withTime(record(uuid.New(), "title", "user", "pass"), pwsafe.LastModificationTime, 400)
+	different := record(uuid.New(), "title", "user", "other")
+	passwords := []pwsafe.PasswordRecord{first, old, different, recent}
+
+	for keep, expected := range map[pwsafe.KeepPolicy]pwsafe.PasswordRecord{
+		pwsafe.KeepFirst:  first,
+		pwsafe.KeepOldest: old,
+		pwsafe.KeepNewest: recent,
+	} {
+		result := pwsafe.Dedup(passwords, pwsafe.DedupOptions{Keep: keep})
+		if diff := cmp.Diff([]pwsafe.PasswordRecord{expected, different}, result.Passwords); diff != "" {
+			t.Errorf("Unexpected records kept by %s (-want +got):\n%s\n", keep, diff)
+		}
+	}
+}
+
+func TestParseKeepPolicy(t *testing.T) {
+	for _, name := range pwsafe.KeepPolicyNames() {
+		k, err := pwsafe.ParseKeepPolicy(name)
+		if err != nil {
+			t.Error(err)
+		}
+		if k.String() != name {
+			t.Errorf("Expected %s, got %s", name, k)
+		}
+	}
+	if _, err := pwsafe.ParseKeepPolicy("bogus"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestDedupMerge(t *testing.T) {
+	a := record(uuid.New(), "title", "user", "pass")
+	a.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(200)}
+	a.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(300)}
+	a.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "first"}
+	a.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "10201" + "00000001" + "0003" + "old"}
+	b := record(uuid.New(), "title", "user", "pass")
+	b.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(100)}
+	b.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(400)}
+	b.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "second"}
+	b.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "10201" + "00000002" + "0005" + "older"}
+	b.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://example.com"}
+	c := record(uuid.New(), "other", "user", "pass")
+
+	result := pwsafe.Dedup([]pwsafe.PasswordRecord{a, c, b}, pwsafe.DedupOptions{
+		Match:          pwsafe.MatchEntry,
+		Merge:          true,
+		NotesSeparator: "\n",
+	})
+	if len(result.Groups) != 0 {
+		t.Fatalf("Expected different URLs not to match, got %d groups", len(result.Groups))
+	}
+
+	delete(b.Fields, pwsafe.URL)
+	result = pwsafe.Dedup([]pwsafe.PasswordRecord{a, c, b}, pwsafe.DedupOptions{
+		Keep:           pwsafe.KeepNewest,
+		Match:          pwsafe.MatchEntry,
+		Merge:          true,
+		NotesSeparator: "\n",
+	})
+	if len(result.Groups) != 1 || !result.Groups[0].Merged {
+		t.Fatalf("Expected 1 merged group, got %+v", result.Groups)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a}, result.Removed); diff != "" {
+		t.Errorf("Unexpected removed (-want +got):\n%s\n", diff)
+	}
+
+	expected := record(uuid.UUID{}, "title", "user", "pass")
+	expected.Fields[pwsafe.UUID] = b.Fields[pwsafe.UUID]
+	expected.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(100)}
+	expected.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(400)}
+	expected.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "second\nfirst"}
+	expected.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory,
+		Data: "10202" + "00000001" + "0003" + "old" + "00000002" + "0005" + "older"}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{expected, c}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected merge (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestDedupMergeConflict(t *testing.T) {
+	a := record(uuid.New(), "title", "user", "pass")
+	b := record(uuid.New(), "title", "other", "pass")
+
+	result := pwsafe.Dedup([]pwsafe.PasswordRecord{a, b}, pwsafe.DedupOptions{
+		Match: pwsafe.MatchEntry,
+		Merge: true,
+	})
+	if len(result.Passwords) != 2 || len(result.Removed) != 0 {
+		t.Errorf("Expected both records kept, got %d kept %d removed",
+			len(result.Passwords), len(result.Removed))
+	}
+	if len(result.Groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(result.Groups))
+	}
+	if diff := cmp.Diff([]byte{pwsafe.Username}, result.Groups[0].Conflicts); diff != "" {
+		t.Errorf("Unexpected conflicts (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestNewCredentials(t *testing.T) {
+	existing := record(uuid.New(), "bank", "User ", "secret")
+	existing.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "http://www.bank.example"}
+
+	same := record(uuid.New(), "bank.example", "user", "secret")
+	same.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example/login"}
+	changed := record(uuid.New(), "bank.example", "user", "new secret")
+	changed.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example/login"}
+	again := record(uuid.New(), "again", "user", "new secret")
+	again.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example/"}
+
+	added, skipped := pwsafe.NewCredentials([]pwsafe.PasswordRecord{existing}, []pwsafe.PasswordRecord{same, changed, again})
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{changed}, added); diff != "" {
+		t.Errorf("Unexpected added (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{same, again}, skipped); diff != "" {
+		t.Errorf("Unexpected skipped (-want +got):\n%s\n", diff)
+	}
+
+	// re-imported with a new UUID and no URL, and notes that only match
+	// exactly
+	copied := record(uuid.New(), "Email", "me", "hunter2")
+	other := record(uuid.New(), "Work email", "me", "hunter2")
+	note := pwsafe.NewPasswordRecord()
+	note.Fields[pwsafe.Title] = pwsafe.Field{Type: pwsafe.Title, Data: "note"}
+	note.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "remember this"}
+	otherNote := pwsafe.NewPasswordRecord()
+	otherNote.Fields[pwsafe.Title] = pwsafe.Field{Type: pwsafe.Title, Data: "note"}
+	otherNote.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "and this"}
+	safe := []pwsafe.PasswordRecord{record(uuid.New(), "email", "me", "hunter2"), note}
+
+	added, skipped = pwsafe.NewCredentials(safe, []pwsafe.PasswordRecord{copied, other, note, otherNote})
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{other, otherNote}, added); diff != "" {
+		t.Errorf("Unexpected added (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{copied, note}, skipped); diff != "" {
+		t.Errorf("Unexpected skipped (-want +got):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,149 @@
+package pwsafe
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// FieldChange is a field that's different in two versions of a record.
+// Secrets don't have their values filled in, only that they changed.
+type FieldChange struct {
+	Type   byte
+	Secret bool
+	// "" when the field isn't in that version
+	Old, New string
+}
+
+func (c FieldChange) String() string {
+	if c.Secret {
+		return FieldName(c.Type) + ": changed"
+	}
+	return fmt.Sprintf("%s: %q -> %q", FieldName(c.Type), c.Old, c.New)
+}
+
+// RecordDiff is a record that's in both safes but different.
+type RecordDiff struct {
+	A, B   PasswordRecord
+	Fields []FieldChange
+}
+
+type DiffResult struct {
+	// only in b
+	Added []PasswordRecord
+	// only in a
+	Removed []PasswordRecord
+	Changed []RecordDiff
+}
+
+// Empty is true when the safes have the same records.
+func (d *DiffResult) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// Diff compares the records in two safes.  Records are matched by UUID, and
+// those that can't be are matched by title, username and URL.
+func Diff(a, b *V3File) DiffResult {
+	matches := matchRecords(a.Passwords, b.Passwords)
+
+	var result DiffResult
+	matched := make(map[int]bool)
+	for i := range a.Passwords {
+		j, ok := matches[i]
+		if !ok {
+			result.Removed = append(result.Removed, a.Passwords[i])
+			continue
+		}
+		matched[j] = true
+		if changes := DiffRecords(&a.Passwords[i], &b.Passwords[j]); len(changes) > 0 {
+			result.Changed = append(result.Changed, RecordDiff{A: a.Passwords[i], B: b.Passwords[j], Fields: changes})
+		}
+	}
+	for j := range b.Passwords {
+		if !matched[j] {
+			result.Added = append(result.Added, b.Passwords[j])
+		}
+	}
+	return result
+}
+
+// matchRecords pairs up the records in a with those in b, returning the
+// index in b for each index in a that has a match.
+func matchRecords(a, b []PasswordRecord) map[int]int {
+	matches := make(map[int]int)
+	taken := make(map[int]bool)
+
+	byUUID := make(map[string]int)
+	for j := range b {
+		if id, ok := b[j].ID(); ok {
+			if _, seen := byUUID[id.String()]; !seen {
+				byUUID[id.String()] = j
+			}
+		}
+	}
+	for i := range a {
+		id, ok := a[i].ID()
+		if !ok {
+			continue
+		}
+		if j, ok := byUUID[id.String()]; ok && !taken[j] {
+			matches[i] = j
+			taken[j] = true
+		}
+	}
+
+	byEntry := make(map[string][]int)
+	for j := range b {
+		if !taken[j] {
+			k := entryKey(&b[j])
+			byEntry[k] = append(byEntry[k], j)
+		}
+	}
+	for i := range a {
+		if _, ok := matches[i]; ok {
+			continue
+		}
+		k := entryKey(&a[i])
+		if js := byEntry[k]; len(js) > 0 {
+			matches[i] = js[0]
+			byEntry[k] = js[1:]
+		}
+	}
+	return matches
+}
+
+func entryKey(p *PasswordRecord) string {
+	return p.Text(Title) + "\x00" + p.Text(Username) + "\x00" + p.Text(URL)
+}
+
+// DiffRecords lists the fields that differ between two records.
+func DiffRecords(a, b *PasswordRecord) []FieldChange {
+	types := make(map[byte]bool)
+	for k := range a.Fields {
+		types[k] = true
+	}
+	for k := range b.Fields {
+		types[k] = true
+	}
+	var keys []byte
+	for k := range types {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var changes []FieldChange
+	for _, k := range keys {
+		fa, inA := a.Fields[k]
+		fb, inB := b.Fields[k]
+		if inA == inB && fa.String() == fb.String() {
+			continue
+		}
+		c := FieldChange{Type: k, Secret: slices.Contains(SecretFields, k)}
+		if !c.Secret {
+			c.Old, c.New = a.Display(k, time.DateTime), b.Display(k, time.DateTime)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
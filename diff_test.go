@@ -0,0 +1,49 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestDiff(t *testing.T) {
+	same := record(uuid.New(), "same", "user", "pass")
+	removed := record(uuid.New(), "removed", "user", "pass")
+	changed := record(uuid.New(), "changed", "user", "pass")
+	changedB := record(uuid.UUID{}, "changed", "user", "new pass")
+	changedB.Fields[pwsafe.UUID] = changed.Fields[pwsafe.UUID]
+	changedB.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "note"}
+	changedB.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(1600000000)}
+	// a copy made on another machine gets a new UUID
+	copied := record(uuid.New(), "copied", "user", "pass")
+	copiedB := record(uuid.New(), "copied", "user", "pass")
+	added := record(uuid.New(), "added", "user", "pass")
+
+	a := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{same, removed, changed, copied}}
+	b := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{copiedB, added, changedB, same}}
+
+	result := pwsafe.Diff(&a, &b)
+
+	expected := pwsafe.DiffResult{
+		Added:   []pwsafe.PasswordRecord{added},
+		Removed: []pwsafe.PasswordRecord{removed},
+		Changed: []pwsafe.RecordDiff{
+			{A: changed, B: changedB, Fields: []pwsafe.FieldChange{
+				{Type: pwsafe.Notes, New: "note"},
+				{Type: pwsafe.Password, Secret: true},
+				{Type: pwsafe.CreationTime, New: "2020-09-13 12:26:40"},
+			}},
+			{A: copied, B: copiedB, Fields: []pwsafe.FieldChange{
+				{Type: pwsafe.UUID, Old: copied.Fields[pwsafe.UUID].Data.(uuid.UUID).String(), New: copiedB.Fields[pwsafe.UUID].Data.(uuid.UUID).String()},
+			}},
+		},
+	}
+	if diff := cmp.Diff(expected, result); diff != "" {
+		t.Errorf("Unexpected diff (-want +got):\n%s\n", diff)
+	}
+	if result.Changed[0].Fields[1].String() != "Password: changed" {
+		t.Errorf("Expected the password to be hidden, got %s", result.Changed[0].Fields[1])
+	}
+}
@@ -0,0 +1,117 @@
+package pwsafe
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// short names for fields, as well as the names FieldName gives.
+var fieldAliases = map[string]byte{
+	"accessed": LastAccessTime,
+	"card":     CreditCardNumber,
+	"created":  CreationTime,
+	"cvv":      CreditCardVerifValue,
+	"email":    EMailAddress,
+	"expiry":   CreditCardExpiration,
+	"history":  PasswordHistory,
+	"modified": LastModificationTime,
+	"pin":      CreditCardPIN,
+	"totp":     TwoFactorKey,
+	"user":     Username,
+}
+
+// ParseFieldName returns the field type for a name, either one of the names
+// FieldName gives or a short alias like "modified" or "totp".  Case is
+// ignored.
+func ParseFieldName(name string) (byte, error) {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if t, ok := fieldAliases[lower]; ok {
+		return t, nil
+	}
+	for t := 0; t < 0xff; t++ {
+		if strings.ToLower(FieldName(byte(t))) == lower && !strings.HasPrefix(lower, "unknown") {
+			return byte(t), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown field %q", name)
+}
+
+// ParseFieldNames parses a comma separated list of field names.
+func ParseFieldNames(names string) ([]byte, error) {
+	var types []byte
+	for _, name := range strings.Split(names, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		t, err := ParseFieldName(name)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// Filter selects records.  Empty criteria match everything.
+type Filter struct {
+	// the group or one of its parents, e.g. "web" matches "web" and
+	// "web.shops" but not "webmail"
+	Group string
+	// matched against all the fields that aren't secret
+	Pattern *regexp.Regexp
+	// fields that must be present
+	Has []byte
+}
+
+func (f *Filter) Match(p *PasswordRecord) bool {
+	if f.Group != "" {
+		g := p.Text(Group)
+		if g != f.Group && !strings.HasPrefix(g, f.Group+".") {
+			return false
+		}
+	}
+	for _, t := range f.Has {
+		if _, ok := p.Fields[t]; !ok {
+			return false
+		}
+	}
+	if f.Pattern != nil {
+		for _, k := range p.sortedFieldKey() {
+			if slices.Contains(SecretFields, k) {
+				continue
+			}
+			if f.Pattern.MatchString(fieldText(p.Fields[k])) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Filter returns the records that match.
+func (f *Filter) Filter(passwords []PasswordRecord) []PasswordRecord {
+	var matched []PasswordRecord
+	for _, p := range passwords {
+		if f.Match(&p) {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
+
+// fieldText gives the value of text and UUID fields, binary fields are
+// ignored.
+func fieldText(f Field) string {
+	switch v := f.Data.(type) {
+	case string:
+		return v
+	case uuid.UUID:
+		return v.String()
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package pwsafe_test
+
+import (
+	"regexp"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestFilter(t *testing.T) {
+	a := record(uuid.New(), "bank", "alice", "secret")
+	a.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "money.banks"}
+	b := record(uuid.New(), "shop", "bob", "pass")
+	b.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "moneyish"}
+	b.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://shop.example.com"}
+	c := record(uuid.New(), "mail", "carol", "example")
+	passwords := []pwsafe.PasswordRecord{a, b, c}
+
+	tests := []struct {
+		name     string
+		filter   pwsafe.Filter
+		expected []pwsafe.PasswordRecord
+	}{
+		{"everything", pwsafe.Filter{}, passwords},
+		{"group", pwsafe.Filter{Group: "money"}, []pwsafe.PasswordRecord{a}},
+		{"subgroup", pwsafe.Filter{Group: "money.banks"}, []pwsafe.PasswordRecord{a}},
+		{"pattern", pwsafe.Filter{Pattern: regexp.MustCompile("example")}, []pwsafe.PasswordRecord{b}},
+		{"pattern on secret", pwsafe.Filter{Pattern: regexp.MustCompile("secret")}, nil},
+		{"has", pwsafe.Filter{Has: []byte{pwsafe.URL}}, []pwsafe.PasswordRecord{b}},
+		{"group and has", pwsafe.Filter{Group: "money", Has: []byte{pwsafe.URL}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.Filter(passwords)
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("Unexpected records (-want +got):\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestParseFieldNames(t *testing.T) {
+	types, err := pwsafe.ParseFieldNames("group, Title,modified,totp,uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{pwsafe.Group, pwsafe.Title, pwsafe.LastModificationTime, pwsafe.TwoFactorKey, pwsafe.UUID}
+	if diff := cmp.Diff(expected, types); diff != "" {
+		t.Errorf("Unexpected types (-want +got):\n%s\n", diff)
+	}
+	if _, err := pwsafe.ParseFieldNames("title,bogus"); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
@@ -0,0 +1,35 @@
+package pwsafe
+
+import "strings"
+
+// SplitGroupPath splits a group into its parts.  Groups are separated by
+// dots, and a dot that's part of a name is escaped with a backslash.
+func SplitGroupPath(group string) []string {
+	if group == "" {
+		return nil
+	}
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(group); i++ {
+		switch {
+		case group[i] == '\\' && i+1 < len(group) && group[i+1] == '.':
+			b.WriteByte('.')
+			i++
+		case group[i] == '.':
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(group[i])
+		}
+	}
+	return append(parts, b.String())
+}
+
+// JoinGroupPath is the reverse of SplitGroupPath.
+func JoinGroupPath(parts []string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = strings.ReplaceAll(p, ".", `\.`)
+	}
+	return strings.Join(escaped, ".")
+}
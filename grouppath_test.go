@@ -0,0 +1,29 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGroupPath(t *testing.T) {
+	tests := []struct {
+		group string
+		parts []string
+	}{
+		{"", nil},
+		{"web", []string{"web"}},
+		{"web.shops", []string{"web", "shops"}},
+		{`web.example\.com`, []string{"web", "example.com"}},
+	}
+	for _, tt := range tests {
+		parts := pwsafe.SplitGroupPath(tt.group)
+		if diff := cmp.Diff(tt.parts, parts); diff != "" {
+			t.Errorf("Unexpected parts for %q (-want +got):\n%s\n", tt.group, diff)
+		}
+		if group := pwsafe.JoinGroupPath(parts); group != tt.group {
+			t.Errorf("Expected %q, got %q", tt.group, group)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pwsafe
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// History is the parsed form of the PasswordHistory field.
+//
+// The field is stored as "fmmnn" followed by nn entries of
+// "TTTTTTTTLLLLPPPP...", all numbers in hex.  f is 1 when history is being
+// kept, mm is the max number of entries, TTTTTTTT is the time_t the password
+// was changed and LLLL is the length of the old password PPPP.
+type History struct {
+	Enabled bool
+	Max     int
+	Entries []HistoryEntry
+}
+
+type HistoryEntry struct {
+	Changed  uint32
+	Password string
+}
+
+func ParseHistory(s string) (History, error) {
+	if len(s) < 5 {
+		return History{}, fmt.Errorf("password history too short")
+	}
+	h := History{Enabled: s[0] == '1'}
+	max, err := strconv.ParseUint(s[1:3], 16, 8)
+	if err != nil {
+		return History{}, fmt.Errorf("password history max invalid: %w", err)
+	}
+	num, err := strconv.ParseUint(s[3:5], 16, 8)
+	if err != nil {
+		return History{}, fmt.Errorf("password history count invalid: %w", err)
+	}
+	h.Max = int(max)
+
+	rest := s[5:]
+	for i := uint64(0); i < num; i++ {
+		if len(rest) < 12 {
+			return History{}, fmt.Errorf("password history entry %d truncated", i)
+		}
+		changed, err := strconv.ParseUint(rest[0:8], 16, 32)
+		if err != nil {
+			return History{}, fmt.Errorf("password history entry %d time invalid: %w", i, err)
+		}
+		length, err := strconv.ParseUint(rest[8:12], 16, 16)
+		if err != nil {
+			return History{}, fmt.Errorf("password history entry %d length invalid: %w", i, err)
+		}
+		rest = rest[12:]
+		// the length is in characters, not bytes
+		end := 0
+		for n := uint64(0); n < length; n++ {
+			if end >= len(rest) {
+				return History{}, fmt.Errorf("password history entry %d truncated", i)
+			}
+			_, size := utf8.DecodeRuneInString(rest[end:])
+			end += size
+		}
+		h.Entries = append(h.Entries, HistoryEntry{Changed: uint32(changed), Password: rest[:end]})
+		rest = rest[end:]
+	}
+	return h, nil
+}
+
+func (h History) String() string {
+	var b strings.Builder
+	if h.Enabled {
+		b.WriteString("1")
+	} else {
+		b.WriteString("0")
+	}
+	fmt.Fprintf(&b, "%02x%02x", h.Max, len(h.Entries))
+	for _, e := range h.Entries {
+		fmt.Fprintf(&b, "%08x%04x%s", e.Changed, utf8.RuneCountInString(e.Password), e.Password)
+	}
+	return b.String()
+}
+
+// MergeHistory returns the union of the entries in both histories, oldest
+// first.  Max is raised if necessary so that no entries are lost.
+func MergeHistory(a, b History) History {
+	merged := History{Enabled: a.Enabled || b.Enabled, Max: a.Max}
+	if b.Max > merged.Max {
+		merged.Max = b.Max
+	}
+	seen := make(map[HistoryEntry]bool)
+	for _, e := range append(append([]HistoryEntry(nil), a.Entries...), b.Entries...) {
+		if !seen[e] {
+			seen[e] = true
+			merged.Entries = append(merged.Entries, e)
+		}
+	}
+	sort.SliceStable(merged.Entries, func(i, j int) bool {
+		return merged.Entries[i].Changed < merged.Entries[j].Changed
+	})
+	if len(merged.Entries) > 0xff {
+		merged.Entries = merged.Entries[len(merged.Entries)-0xff:]
+	}
+	if len(merged.Entries) > merged.Max {
+		merged.Max = len(merged.Entries)
+	}
+	return merged
+}
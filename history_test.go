@@ -0,0 +1,53 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseHistory(t *testing.T) {
+	raw := "10502" + "5e0be100" + "0003" + "abc" + "5e0be200" + "0004" + "päss"
+	h, err := pwsafe.ParseHistory(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := pwsafe.History{
+		Enabled: true,
+		Max:     5,
+		Entries: []pwsafe.HistoryEntry{
+			{Changed: 0x5e0be100, Password: "abc"},
+			{Changed: 0x5e0be200, Password: "päss"},
+		},
+	}
+	if diff := cmp.Diff(expected, h); diff != "" {
+		t.Errorf("Unexpected history (-want +got):\n%s\n", diff)
+	}
+	if h.String() != raw {
+		t.Errorf("Expected %q, got %q", raw, h.String())
+	}
+
+	if _, err := pwsafe.ParseHistory("10501" + "5e0be100" + "0010" + "abc"); err == nil {
+		t.Error("Expected error for truncated history")
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	a := pwsafe.History{Enabled: true, Max: 2, Entries: []pwsafe.HistoryEntry{
+		{Changed: 1, Password: "one"},
+		{Changed: 3, Password: "three"},
+	}}
+	b := pwsafe.History{Max: 2, Entries: []pwsafe.HistoryEntry{
+		{Changed: 2, Password: "two"},
+		{Changed: 3, Password: "three"},
+	}}
+	expected := pwsafe.History{Enabled: true, Max: 3, Entries: []pwsafe.HistoryEntry{
+		{Changed: 1, Password: "one"},
+		{Changed: 2, Password: "two"},
+		{Changed: 3, Password: "three"},
+	}}
+	if diff := cmp.Diff(expected, pwsafe.MergeHistory(a, b)); diff != "" {
+		t.Errorf("Unexpected history (-want +got):\n%s\n", diff)
+	}
+}
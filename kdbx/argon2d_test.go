@@ -0,0 +1,71 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestArgon2RFC9106(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	for _, test := range []struct {
+		mode     uint32
+		expected string
+	}{
+		{argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	} {
+		key := argon2Key(test.mode, password, salt, secret, data, 3, 32, 4, 32)
+		if got := hex.EncodeToString(key); got != test.expected {
+			t.Errorf("Mode %d expected %s, got %s", test.mode, test.expected, got)
+		}
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	// the same as the library, including where a segment needs more than
+	// one block of addresses
+	for _, p := range []struct {
+		time, memory uint32
+		threads      uint8
+		keyLen       uint32
+	}{
+		{1, 8, 1, 32},
+		{2, 64, 2, 16},
+		{3, 1024, 1, 100},
+		{1, 2100, 3, 64},
+	} {
+		expected := argon2.IDKey([]byte("password"), []byte("somesalt"), p.time, p.memory, p.threads, p.keyLen)
+		got := argon2Key(argon2id, []byte("password"), []byte("somesalt"), nil, nil, p.time, p.memory, p.threads, p.keyLen)
+		if !bytes.Equal(expected, got) {
+			t.Errorf("Unexpected key for %+v", p)
+		}
+	}
+}
+
+func TestTransformKeyArgon2d(t *testing.T) {
+	salt := bytes.Repeat([]byte{2}, 32)
+	kdf := variants{
+		"$UUID": argon2dKDF[:],
+		"S":     salt,
+		"I":     uint64(2),
+		"M":     uint64(64 << 10),
+		"P":     uint32(2),
+		"V":     uint32(0x13),
+	}
+	key, err := transformKey([]byte("password"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw := sha256.Sum256([]byte("password"))
+	composite := sha256.Sum256(pw[:])
+	if expected := argon2Key(argon2d, composite[:], salt, nil, nil, 2, 64, 2, 32); !bytes.Equal(expected, key) {
+		t.Errorf("Expected the key from Argon2d")
+	}
+}
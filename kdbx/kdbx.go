@@ -0,0 +1,536 @@
+// Package kdbx reads and writes KeePass KDBX 4 files, converting them to
+// and from the same model as a Password Safe.  The XML inside is the KeePass
+// XML format pwsafe.WriteKeePassXML writes and pwsafe.ReadKeePassXML reads.
+//
+// https://keepass.info/help/kb/kdbx_4.html
+package kdbx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	signature1 = 0x9aa2d903
+	signature2 = 0xb54bfb67
+	// 4.0, the minor version is in the low 16 bits
+	version4 = 0x00040000
+)
+
+// the outer header fields
+const (
+	endOfHeader      = 0
+	cipherID         = 2
+	compressionFlags = 3
+	masterSeed       = 4
+	encryptionIV     = 7
+	kdfParameters    = 11
+)
+
+// the inner header fields
+const (
+	innerEndOfHeader = 0
+	innerStreamID    = 1
+	innerStreamKey   = 2
+	innerBinary      = 3
+)
+
+// the only inner random stream KDBX 4 writes
+const chaCha20Stream = 3
+
+// the HMAC of the data is in blocks of up to this size
+const blockSize = 1 << 20
+
+var (
+	aesCipher      = uuid.MustParse("31c1f2e6-bf71-4350-be58-05216afc5aff")
+	chaCha20Cipher = uuid.MustParse("d6038a2b-8b6f-4cb5-a524-339a31dbb59a")
+	argon2idKDF    = uuid.MustParse("9e298b19-56db-4773-b23d-fc3ec6f0a1e6")
+	argon2dKDF     = uuid.MustParse("ef636ddf-8c29-444b-91f7-a9a403e30a0c")
+	aesKDF         = uuid.MustParse("c9d9f39a-628a-4460-bf74-0d08c18a4fea")
+)
+
+// Cipher is how the database is encrypted.
+type Cipher int
+
+const (
+	ChaCha20 Cipher = iota
+	AES
+)
+
+var cipherNames = []string{
+	ChaCha20: "chacha20",
+	AES:      "aes",
+}
+
+func (c Cipher) String() string {
+	if c < 0 || int(c) >= len(cipherNames) {
+		return fmt.Sprintf("Cipher(%d)", int(c))
+	}
+	return cipherNames[c]
+}
+
+// CipherNames lists the names accepted by ParseCipher.
+func CipherNames() []string {
+	return append([]string(nil), cipherNames...)
+}
+
+func ParseCipher(name string) (Cipher, error) {
+	for i, n := range cipherNames {
+		if n == name {
+			return Cipher(i), nil
+		}
+	}
+	return ChaCha20, fmt.Errorf("unknown cipher %q, expected one of %s",
+		name, strings.Join(cipherNames, ", "))
+}
+
+// Options are how Write encrypts the database.  The key is derived from the
+// password with Argon2id.
+type Options struct {
+	Cipher      Cipher
+	Iterations  uint64
+	Memory      uint64 // in bytes
+	Parallelism uint32
+}
+
+// DefaultOptions take around a second to open on a laptop.
+var DefaultOptions = Options{
+	Cipher:      ChaCha20,
+	Iterations:  10,
+	Memory:      64 << 20,
+	Parallelism: 2,
+}
+
+type header struct {
+	cipher     uuid.UUID
+	compressed bool
+	seed       []byte
+	iv         []byte
+	kdf        variants
+}
+
+// Write writes the safe as a KDBX 4 file encrypted with the password.
+func Write(w io.Writer, v3 *pwsafe.V3File, password []byte, opts Options) error {
+	if opts.Memory < 8<<10 || opts.Memory>>10 > 1<<32-1 || opts.Iterations == 0 ||
+		opts.Parallelism == 0 || opts.Parallelism > 0xff {
+		return fmt.Errorf("invalid argon2 parameters")
+	}
+	h := header{
+		cipher:     chaCha20Cipher,
+		compressed: true,
+		seed:       random(32),
+		iv:         random(12),
+		kdf: variants{
+			"$UUID": argon2idKDF[:],
+			"S":     random(32),
+			"I":     opts.Iterations,
+			"M":     opts.Memory,
+			"P":     opts.Parallelism,
+			"V":     uint32(0x13),
+		},
+	}
+	if opts.Cipher == AES {
+		h.cipher, h.iv = aesCipher, random(16)
+	}
+
+	var x bytes.Buffer
+	if err := pwsafe.WriteKeePassXML(&x, v3); err != nil {
+		return err
+	}
+	streamKey := random(64)
+	var inner bytes.Buffer
+	writeField(&inner, innerStreamID, binary.LittleEndian.AppendUint32(nil, chaCha20Stream))
+	writeField(&inner, innerStreamKey, streamKey)
+	writeField(&inner, innerEndOfHeader, nil)
+	if err := protect(&inner, &x, newInnerStream(streamKey)); err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	gz := gzip.NewWriter(&content)
+	if _, err := gz.Write(inner.Bytes()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	key, err := transformKey(password, h.kdf)
+	if err != nil {
+		return err
+	}
+	encryptionKey, hmacKey := keys(h.seed, key)
+	encrypted, err := crypt(h, encryptionKey, content.Bytes(), true)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	out.Write(h.bytes())
+	sum := sha256.Sum256(out.Bytes())
+	mac := hmac.New(sha256.New, blockKey(hmacKey, ^uint64(0)))
+	mac.Write(out.Bytes())
+	out.Write(sum[:])
+	out.Write(mac.Sum(nil))
+	for i := uint64(0); ; i++ {
+		n := min(len(encrypted), blockSize)
+		out.Write(blockHMAC(hmacKey, i, encrypted[:n]))
+		out.Write(binary.LittleEndian.AppendUint32(nil, uint32(n)))
+		out.Write(encrypted[:n])
+		if n == 0 {
+			break
+		}
+		encrypted = encrypted[n:]
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func (h *header) bytes() []byte {
+	var b bytes.Buffer
+	b.Write(binary.LittleEndian.AppendUint32(nil, signature1))
+	b.Write(binary.LittleEndian.AppendUint32(nil, signature2))
+	b.Write(binary.LittleEndian.AppendUint32(nil, version4))
+	writeField(&b, cipherID, h.cipher[:])
+	var compression uint32
+	if h.compressed {
+		compression = 1
+	}
+	writeField(&b, compressionFlags, binary.LittleEndian.AppendUint32(nil, compression))
+	writeField(&b, masterSeed, h.seed)
+	writeField(&b, encryptionIV, h.iv)
+	writeField(&b, kdfParameters, h.kdf.bytes())
+	writeField(&b, endOfHeader, []byte("\r\n\r\n"))
+	return b.Bytes()
+}
+
+func writeField(b *bytes.Buffer, typeID byte, data []byte) {
+	b.WriteByte(typeID)
+	b.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	b.Write(data)
+}
+
+// Read reads a KDBX 4 file.  A wrong password gives
+// pwsafe.ErrIncorrectPassword, and a damaged file an error wrapping
+// pwsafe.ErrCorrupt.
+func Read(r io.Reader, password []byte) (pwsafe.V3File, error) {
+	br := bufio.NewReader(r)
+	var raw bytes.Buffer
+	h, err := readHeader(io.TeeReader(br, &raw))
+	if err != nil {
+		return pwsafe.V3File{}, err
+	}
+	var sum, headerHMAC [32]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return pwsafe.V3File{}, fmt.Errorf("%w: file truncated", pwsafe.ErrCorrupt)
+	}
+	if _, err := io.ReadFull(br, headerHMAC[:]); err != nil {
+		return pwsafe.V3File{}, fmt.Errorf("%w: file truncated", pwsafe.ErrCorrupt)
+	}
+	if sha256.Sum256(raw.Bytes()) != sum {
+		return pwsafe.V3File{}, fmt.Errorf("%w: header hash doesn't match", pwsafe.ErrCorrupt)
+	}
+
+	key, err := transformKey(password, h.kdf)
+	if err != nil {
+		return pwsafe.V3File{}, err
+	}
+	encryptionKey, hmacKey := keys(h.seed, key)
+	mac := hmac.New(sha256.New, blockKey(hmacKey, ^uint64(0)))
+	mac.Write(raw.Bytes())
+	if !hmac.Equal(mac.Sum(nil), headerHMAC[:]) {
+		return pwsafe.V3File{}, pwsafe.ErrIncorrectPassword
+	}
+
+	var encrypted []byte
+	for i := uint64(0); ; i++ {
+		var blockHeader struct {
+			HMAC   [32]byte
+			Length uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &blockHeader); err != nil {
+			return pwsafe.V3File{}, fmt.Errorf("%w: block %d truncated", pwsafe.ErrCorrupt, i)
+		}
+		if blockHeader.Length > 1<<30 {
+			return pwsafe.V3File{}, fmt.Errorf("%w: block length %d seems too large", pwsafe.ErrCorrupt, blockHeader.Length)
+		}
+		block := make([]byte, blockHeader.Length)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return pwsafe.V3File{}, fmt.Errorf("%w: block %d truncated", pwsafe.ErrCorrupt, i)
+		}
+		if !hmac.Equal(blockHMAC(hmacKey, i, block), blockHeader.HMAC[:]) {
+			return pwsafe.V3File{}, fmt.Errorf("%w: HMAC of block %d doesn't match", pwsafe.ErrCorrupt, i)
+		}
+		if len(block) == 0 {
+			break
+		}
+		encrypted = append(encrypted, block...)
+	}
+
+	content, err := crypt(h, encryptionKey, encrypted, false)
+	if err != nil {
+		return pwsafe.V3File{}, err
+	}
+	var inner io.Reader = bytes.NewReader(content)
+	if h.compressed {
+		gz, err := gzip.NewReader(inner)
+		if err != nil {
+			return pwsafe.V3File{}, fmt.Errorf("%w: %s", pwsafe.ErrCorrupt, err)
+		}
+		inner = gz
+	}
+	stream, err := readInnerHeader(inner)
+	if err != nil {
+		return pwsafe.V3File{}, err
+	}
+	var x bytes.Buffer
+	if err := unprotect(&x, inner, stream); err != nil {
+		return pwsafe.V3File{}, err
+	}
+	return pwsafe.ReadKeePassXML(&x)
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var start struct {
+		Signature1, Signature2, Version uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &start); err != nil {
+		return header{}, fmt.Errorf("%w: file truncated", pwsafe.ErrCorrupt)
+	}
+	if start.Signature1 != signature1 || start.Signature2 != signature2 {
+		return header{}, fmt.Errorf("not a KeePass file")
+	}
+	if start.Version&0xffff0000 != version4 {
+		return header{}, fmt.Errorf("KDBX version %d.%d isn't supported", start.Version>>16, start.Version&0xffff)
+	}
+
+	var h header
+	for {
+		typeID, data, err := readField(r)
+		if err != nil {
+			return header{}, err
+		}
+		switch typeID {
+		case endOfHeader:
+			if h.seed == nil || h.iv == nil || h.kdf == nil {
+				return header{}, fmt.Errorf("%w: header incomplete", pwsafe.ErrCorrupt)
+			}
+			return h, nil
+		case cipherID:
+			u, err := uuid.FromBytes(data)
+			if err != nil {
+				return header{}, fmt.Errorf("%w: bad cipher", pwsafe.ErrCorrupt)
+			}
+			h.cipher = u
+		case compressionFlags:
+			if len(data) != 4 {
+				return header{}, fmt.Errorf("%w: bad compression flags", pwsafe.ErrCorrupt)
+			}
+			h.compressed = binary.LittleEndian.Uint32(data) == 1
+		case masterSeed:
+			if len(data) != 32 {
+				return header{}, fmt.Errorf("%w: bad master seed", pwsafe.ErrCorrupt)
+			}
+			h.seed = data
+		case encryptionIV:
+			h.iv = data
+		case kdfParameters:
+			h.kdf, err = readVariants(data)
+			if err != nil {
+				return header{}, err
+			}
+		}
+		// anything else, like the public custom data, isn't needed
+	}
+}
+
+func readField(r io.Reader) (byte, []byte, error) {
+	var field struct {
+		Type   byte
+		Length uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &field); err != nil {
+		return 0, nil, fmt.Errorf("%w: header truncated", pwsafe.ErrCorrupt)
+	}
+	if field.Length > 1<<30 {
+		return 0, nil, fmt.Errorf("%w: header field length %d seems too large", pwsafe.ErrCorrupt, field.Length)
+	}
+	data := make([]byte, field.Length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("%w: header truncated", pwsafe.ErrCorrupt)
+	}
+	return field.Type, data, nil
+}
+
+// readInnerHeader reads the header at the start of the decrypted data,
+// returning the stream the protected values are encrypted with.
+// Attachments aren't supported, so they're skipped.
+func readInnerHeader(r io.Reader) (*chacha20.Cipher, error) {
+	var streamID uint32
+	var key []byte
+	for {
+		typeID, data, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		switch typeID {
+		case innerEndOfHeader:
+			if streamID != chaCha20Stream || len(key) == 0 {
+				return nil, fmt.Errorf("only the ChaCha20 inner stream is supported")
+			}
+			return newInnerStream(key), nil
+		case innerStreamID:
+			if len(data) != 4 {
+				return nil, fmt.Errorf("%w: bad inner stream", pwsafe.ErrCorrupt)
+			}
+			streamID = binary.LittleEndian.Uint32(data)
+		case innerStreamKey:
+			key = data
+		}
+	}
+}
+
+func newInnerStream(key []byte) *chacha20.Cipher {
+	sum := sha512.Sum512(key)
+	c, err := chacha20.NewUnauthenticatedCipher(sum[:32], sum[32:44])
+	if err != nil {
+		// the sizes are always right
+		panic(err)
+	}
+	return c
+}
+
+// transformKey derives the key from the password with the KDF in the
+// parameters.
+func transformKey(password []byte, kdf variants) ([]byte, error) {
+	// the composite key is the hash of the hashes of each part, and there's
+	// only a password
+	pw := sha256.Sum256(password)
+	composite := sha256.Sum256(pw[:])
+
+	id, err := uuid.FromBytes(kdf.bytesValue("$UUID"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad KDF", pwsafe.ErrCorrupt)
+	}
+	switch id {
+	case argon2idKDF, argon2dKDF:
+		salt := kdf.bytesValue("S")
+		iterations, _ := kdf["I"].(uint64)
+		memory, _ := kdf["M"].(uint64)
+		parallelism, _ := kdf["P"].(uint32)
+		version, _ := kdf["V"].(uint32)
+		if version != 0x13 || iterations == 0 || iterations > 1<<32-1 ||
+			memory>>10 > 1<<32-1 || parallelism == 0 || parallelism > 0xff {
+			return nil, fmt.Errorf("unsupported argon2 parameters")
+		}
+		secret, data := kdf.bytesValue("K"), kdf.bytesValue("A")
+		if id == argon2idKDF && secret == nil && data == nil {
+			// the library's is faster
+			return argon2.IDKey(composite[:], salt, uint32(iterations), uint32(memory>>10), uint8(parallelism), 32), nil
+		}
+		mode := uint32(argon2d)
+		if id == argon2idKDF {
+			mode = argon2id
+		}
+		return argon2Key(mode, composite[:], salt, secret, data, uint32(iterations), uint32(memory>>10), uint8(parallelism), 32), nil
+	case aesKDF:
+		seed := kdf.bytesValue("S")
+		rounds, _ := kdf["R"].(uint64)
+		block, err := aes.NewCipher(seed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad AES KDF seed", pwsafe.ErrCorrupt)
+		}
+		key := composite
+		for i := uint64(0); i < rounds; i++ {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+		sum := sha256.Sum256(key[:])
+		return sum[:], nil
+	}
+	return nil, fmt.Errorf("unknown KDF %s", id)
+}
+
+func keys(seed, transformed []byte) (encryptionKey, hmacKey []byte) {
+	e := sha256.Sum256(append(append([]byte(nil), seed...), transformed...))
+	h := sha512.Sum512(append(append(append([]byte(nil), seed...), transformed...), 1))
+	return e[:], h[:]
+}
+
+func blockKey(hmacKey []byte, index uint64) []byte {
+	sum := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), hmacKey...))
+	return sum[:]
+}
+
+func blockHMAC(hmacKey []byte, index uint64, data []byte) []byte {
+	mac := hmac.New(sha256.New, blockKey(hmacKey, index))
+	mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+	mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// crypt encrypts or decrypts the data with the cipher in the header.
+func crypt(h header, key, data []byte, encrypt bool) ([]byte, error) {
+	switch h.cipher {
+	case chaCha20Cipher:
+		c, err := chacha20.NewUnauthenticatedCipher(key, h.iv)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", pwsafe.ErrCorrupt, err)
+		}
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out, nil
+	case aesCipher:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(h.iv) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: bad IV", pwsafe.ErrCorrupt)
+		}
+		if encrypt {
+			// PKCS #7 padding
+			n := aes.BlockSize - len(data)%aes.BlockSize
+			data = append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(n)}, n)...)
+			out := make([]byte, len(data))
+			cipher.NewCBCEncrypter(block, h.iv).CryptBlocks(out, data)
+			return out, nil
+		}
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: data isn't a whole number of blocks", pwsafe.ErrCorrupt)
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, h.iv).CryptBlocks(out, data)
+		n := int(out[len(out)-1])
+		if n == 0 || n > aes.BlockSize {
+			return nil, fmt.Errorf("%w: bad padding", pwsafe.ErrCorrupt)
+		}
+		return out[:len(out)-n], nil
+	}
+	return nil, fmt.Errorf("unknown cipher %s", h.cipher)
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
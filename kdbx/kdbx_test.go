@@ -0,0 +1,105 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/colinnewell/pwsafe-de-dup/kdbx"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// cheap enough for tests
+var testOptions = kdbx.Options{Iterations: 1, Memory: 64 << 10, Parallelism: 1}
+
+func testSafe() pwsafe.V3File {
+	p := pwsafe.NewPasswordRecord()
+	for k, v := range map[byte]interface{}{
+		pwsafe.UUID:                 uuid.New(),
+		pwsafe.Group:                "web.banks",
+		pwsafe.Title:                "bank",
+		pwsafe.Username:             "user",
+		pwsafe.Password:             "pass <&> word",
+		pwsafe.Notes:                "line 1\nline 2",
+		pwsafe.CreationTime:         uint32(1600000000),
+		pwsafe.LastModificationTime: uint32(1600000100),
+		pwsafe.PasswordHistory:      "10302" + "5f5e1000" + "0003" + "old" + "5f5e1001" + "0005" + "older",
+		pwsafe.CreditCardPIN:        "1234",
+		pwsafe.EMailAddress:         "user@example.com",
+	} {
+		p.Fields[k] = pwsafe.Field{Type: k, Data: v}
+	}
+	empty := pwsafe.NewPasswordRecord()
+	empty.Fields[pwsafe.UUID] = pwsafe.Field{Type: pwsafe.UUID, Data: uuid.New()}
+	empty.Fields[pwsafe.Title] = pwsafe.Field{Type: pwsafe.Title, Data: "no password"}
+	return pwsafe.V3File{
+		Headers: []pwsafe.HeaderRecord{
+			{Type: pwsafe.DatabaseName, Data: "test"},
+			{Type: pwsafe.EmptyGroups, Data: "empty"},
+		},
+		Passwords: []pwsafe.PasswordRecord{empty, p},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	v3 := testSafe()
+	for _, c := range []kdbx.Cipher{kdbx.ChaCha20, kdbx.AES} {
+		t.Run(c.String(), func(t *testing.T) {
+			opts := testOptions
+			opts.Cipher = c
+			var b bytes.Buffer
+			if err := kdbx.Write(&b, &v3, []byte("password"), opts); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(b.Bytes(), []byte("bank")) {
+				t.Error("Expected the file to be encrypted")
+			}
+			read, err := kdbx.Read(&b, []byte("password"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(v3, read); diff != "" {
+				t.Errorf("Round trip not identical (-wrote +read):\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	v3 := testSafe()
+	var b bytes.Buffer
+	if err := kdbx.Write(&b, &v3, []byte("password"), testOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kdbx.Read(bytes.NewReader(b.Bytes()), []byte("wrong")); !errors.Is(err, pwsafe.ErrIncorrectPassword) {
+		t.Errorf("Expected ErrIncorrectPassword, got %v", err)
+	}
+
+	damaged := bytes.Clone(b.Bytes())
+	damaged[len(damaged)-50] ^= 1
+	if _, err := kdbx.Read(bytes.NewReader(damaged), []byte("password")); !errors.Is(err, pwsafe.ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for damaged data, got %v", err)
+	}
+
+	if _, err := kdbx.Read(bytes.NewReader(b.Bytes()[:100]), []byte("password")); !errors.Is(err, pwsafe.ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a truncated file, got %v", err)
+	}
+}
+
+func TestParseCipher(t *testing.T) {
+	for _, name := range kdbx.CipherNames() {
+		c, err := kdbx.ParseCipher(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.String() != name {
+			t.Errorf("Expected %s, got %s", name, c)
+		}
+	}
+	if _, err := kdbx.ParseCipher("rot13"); err == nil {
+		t.Error("Expected an error for an unknown cipher")
+	}
+}
@@ -0,0 +1,133 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+)
+
+// the value types in a variant dictionary
+const (
+	variantEnd    = 0x00
+	variantUint32 = 0x04
+	variantUint64 = 0x05
+	variantBool   = 0x08
+	variantInt32  = 0x0c
+	variantInt64  = 0x0d
+	variantString = 0x18
+	variantBytes  = 0x42
+)
+
+const variantVersion = 0x0100
+
+// variants is a variant dictionary, used for the KDF parameters.  The values
+// are uint32, uint64, bool, int32, int64, string or []byte.
+type variants map[string]interface{}
+
+func (v variants) bytesValue(name string) []byte {
+	b, _ := v[name].([]byte)
+	return b
+}
+
+func (v variants) bytes() []byte {
+	var b bytes.Buffer
+	b.Write(binary.LittleEndian.AppendUint16(nil, variantVersion))
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var typeID byte
+		var data []byte
+		switch d := v[name].(type) {
+		case uint32:
+			typeID, data = variantUint32, binary.LittleEndian.AppendUint32(nil, d)
+		case uint64:
+			typeID, data = variantUint64, binary.LittleEndian.AppendUint64(nil, d)
+		case bool:
+			typeID, data = variantBool, []byte{0}
+			if d {
+				data[0] = 1
+			}
+		case int32:
+			typeID, data = variantInt32, binary.LittleEndian.AppendUint32(nil, uint32(d))
+		case int64:
+			typeID, data = variantInt64, binary.LittleEndian.AppendUint64(nil, uint64(d))
+		case string:
+			typeID, data = variantString, []byte(d)
+		case []byte:
+			typeID, data = variantBytes, d
+		default:
+			panic(fmt.Sprintf("unexpected variant %T", d))
+		}
+		b.WriteByte(typeID)
+		b.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(name))))
+		b.WriteString(name)
+		b.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+		b.Write(data)
+	}
+	b.WriteByte(variantEnd)
+	return b.Bytes()
+}
+
+func readVariants(data []byte) (variants, error) {
+	corrupt := fmt.Errorf("%w: bad KDF parameters", pwsafe.ErrCorrupt)
+	if len(data) < 2 || binary.LittleEndian.Uint16(data)&0xff00 != variantVersion&0xff00 {
+		return nil, corrupt
+	}
+	data = data[2:]
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil, false
+		}
+		b := data[4 : 4+n]
+		data = data[4+n:]
+		return b, true
+	}
+
+	v := make(variants)
+	for {
+		if len(data) == 0 {
+			return nil, corrupt
+		}
+		typeID := data[0]
+		data = data[1:]
+		if typeID == variantEnd {
+			return v, nil
+		}
+		name, ok := next()
+		if !ok {
+			return nil, corrupt
+		}
+		value, ok := next()
+		if !ok {
+			return nil, corrupt
+		}
+		switch {
+		case typeID == variantUint32 && len(value) == 4:
+			v[string(name)] = binary.LittleEndian.Uint32(value)
+		case typeID == variantUint64 && len(value) == 8:
+			v[string(name)] = binary.LittleEndian.Uint64(value)
+		case typeID == variantBool && len(value) == 1:
+			v[string(name)] = value[0] != 0
+		case typeID == variantInt32 && len(value) == 4:
+			v[string(name)] = int32(binary.LittleEndian.Uint32(value))
+		case typeID == variantInt64 && len(value) == 8:
+			v[string(name)] = int64(binary.LittleEndian.Uint64(value))
+		case typeID == variantString:
+			v[string(name)] = string(value)
+		case typeID == variantBytes:
+			v[string(name)] = value
+		default:
+			return nil, corrupt
+		}
+	}
+}
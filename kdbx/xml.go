@@ -0,0 +1,137 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"golang.org/x/crypto/chacha20"
+)
+
+// seconds from 0001-01-01, which KDBX 4 times count from, to the Unix epoch
+const epoch = 62135596800
+
+// protect converts the XML export to how it's stored in the file.  Values to
+// protect are encrypted with the inner stream, in the order they appear, and
+// times are written as the base64 of the little endian seconds since
+// 0001-01-01.
+func protect(w io.Writer, r io.Reader, stream *chacha20.Cipher) error {
+	return rewrite(w, r, func(path []string, start *xml.StartElement, text string) (string, error) {
+		if len(path) > 1 && path[len(path)-2] == "Times" && path[len(path)-1] != "Expires" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				return "", err
+			}
+			b := binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()+epoch))
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+		if !setAttr(start, "ProtectInMemory", "Protected") {
+			return text, nil
+		}
+		b := []byte(text)
+		stream.XORKeyStream(b, b)
+		return base64.StdEncoding.EncodeToString(b), nil
+	})
+}
+
+// unprotect is the reverse of protect, apart from the times, which
+// pwsafe.ReadKeePassXML reads either way.
+func unprotect(w io.Writer, r io.Reader, stream *chacha20.Cipher) error {
+	return rewrite(w, r, func(path []string, start *xml.StartElement, text string) (string, error) {
+		if !setAttr(start, "Protected", "ProtectInMemory") {
+			return text, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return "", fmt.Errorf("%w: protected value isn't base64", pwsafe.ErrCorrupt)
+		}
+		stream.XORKeyStream(b, b)
+		return string(b), nil
+	})
+}
+
+// setAttr renames the attribute if it's True.
+func setAttr(start *xml.StartElement, from, to string) bool {
+	for i, a := range start.Attr {
+		if a.Name.Local == from && a.Value == "True" {
+			start.Attr[i].Name.Local = to
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite copies the XML, calling change with the text of each element that
+// has no children.  change can also alter the element's attributes.
+func rewrite(w io.Writer, r io.Reader, change func(path []string, start *xml.StartElement, text string) (string, error)) error {
+	dec := xml.NewDecoder(r)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	var path []string
+	// the element that might have no children, and its text so far
+	var leaf *xml.StartElement
+	var text []byte
+	// flush writes the start of the element, with its text if it's a leaf
+	flush := func(isLeaf bool) error {
+		if leaf == nil {
+			return nil
+		}
+		if err := enc.EncodeToken(*leaf); err != nil {
+			return err
+		}
+		leaf = nil
+		if !isLeaf {
+			// the encoder indents the children instead
+			return nil
+		}
+		return enc.EncodeToken(xml.CharData(text))
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", pwsafe.ErrCorrupt, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := flush(false); err != nil {
+				return err
+			}
+			start := t.Copy()
+			leaf, text = &start, nil
+			path = append(path, t.Name.Local)
+			continue
+		case xml.CharData:
+			if leaf != nil {
+				text = append(text, t...)
+				continue
+			}
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		case xml.EndElement:
+			if leaf != nil {
+				s, err := change(path, leaf, string(text))
+				if err != nil {
+					return err
+				}
+				text = []byte(s)
+				if err := flush(true); err != nil {
+					return err
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		if err := enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
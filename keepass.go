@@ -0,0 +1,497 @@
+package pwsafe
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the fields KeePass has a place of its own for.  Everything else is kept
+// as a custom string named by FieldName.
+var keePassStrings = []keePassStandard{
+	{Title, "Title"},
+	{Username, "UserName"},
+	{Password, "Password"},
+	{URL, "URL"},
+	{Notes, "Notes"},
+}
+
+type keePassStandard struct {
+	typeID byte
+	key    string
+}
+
+// keePassFieldTypes finds the field a string is for, by its KeePass name or
+// as a custom field.
+var keePassFieldTypes = func() map[string]byte {
+	types := maps.Clone(customFieldTypes)
+	for _, s := range keePassStrings {
+		types[s.key] = s.typeID
+	}
+	return types
+}()
+
+// KeePass group UUIDs are made from the group path in this namespace, so the
+// same safe always gives the same file.
+var keePassGroupSpace = uuid.MustParse("4c6f5b8e-3f0e-4d4e-9a55-2b8d0f7e6a01")
+
+// seconds from 0001-01-01, which KDBX 4 binary times count from, to the
+// Unix epoch
+const keePassEpoch = 62135596800
+
+type keePassFile struct {
+	XMLName xml.Name    `xml:"KeePassFile"`
+	Meta    keePassMeta `xml:"Meta"`
+	Root    keePassRoot `xml:"Root"`
+}
+
+type keePassMeta struct {
+	Generator           string `xml:"Generator"`
+	DatabaseName        string `xml:"DatabaseName,omitempty"`
+	DatabaseDescription string `xml:"DatabaseDescription,omitempty"`
+	RecycleBinUUID      string `xml:"RecycleBinUUID,omitempty"`
+	HistoryMaxItems     *int   `xml:"HistoryMaxItems"`
+}
+
+type keePassRoot struct {
+	Groups []keePassGroup `xml:"Group"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	UUID    string          `xml:"UUID"`
+	Times   keePassTimes    `xml:"Times"`
+	Strings []keePassString `xml:"String"`
+	History *keePassHistory `xml:"History"`
+}
+
+type keePassTimes struct {
+	CreationTime         string `xml:"CreationTime,omitempty"`
+	LastModificationTime string `xml:"LastModificationTime,omitempty"`
+	LastAccessTime       string `xml:"LastAccessTime,omitempty"`
+	ExpiryTime           string `xml:"ExpiryTime,omitempty"`
+	Expires              string `xml:"Expires,omitempty"`
+}
+
+type keePassString struct {
+	Key   string       `xml:"Key"`
+	Value keePassValue `xml:"Value"`
+}
+
+type keePassValue struct {
+	// set in a KDBX file when the value is encrypted
+	Protected string `xml:"Protected,attr,omitempty"`
+	// set in an XML export for values that were protected
+	ProtectInMemory string `xml:"ProtectInMemory,attr,omitempty"`
+	Value           string `xml:",chardata"`
+}
+
+type keePassHistory struct {
+	Entries []keePassEntry `xml:"Entry"`
+}
+
+// WriteKeePassXML writes the safe in the KeePass 2.x XML export format.
+// Groups become nested groups, the password history becomes older versions
+// of the entry and fields KeePass doesn't have are written as custom strings.
+// Secrets are marked to be protected when imported.
+func WriteKeePassXML(w io.Writer, v3 *V3File) error {
+	name := "Root"
+	var description string
+	var emptyGroups []string
+	for _, h := range v3.Headers {
+		s, _ := h.Data.(string)
+		switch h.Type {
+		case DatabaseName:
+			if s != "" {
+				name = s
+			}
+		case DatabaseDescription:
+			description = s
+		case EmptyGroups:
+			emptyGroups = append(emptyGroups, s)
+		}
+	}
+
+	root := keePassGroup{UUID: keePassGroupUUID(nil), Name: name}
+	historyMax := 0
+	for _, p := range v3.Passwords {
+		e, h := newKeePassEntry(&p)
+		if h != nil {
+			historyMax = max(historyMax, h.Max)
+		}
+		g := root.subgroup(SplitGroupPath(p.Text(Group)))
+		g.Entries = append(g.Entries, e)
+	}
+	for _, group := range emptyGroups {
+		root.subgroup(SplitGroupPath(group))
+	}
+
+	f := keePassFile{
+		Meta: keePassMeta{
+			Generator:           "pwsafe",
+			DatabaseName:        name,
+			DatabaseDescription: description,
+		},
+		Root: keePassRoot{Groups: []keePassGroup{root}},
+	}
+	if historyMax > 0 {
+		f.Meta.HistoryMaxItems = &historyMax
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// subgroup finds the group at the path under g, adding any that are
+// missing.
+func (g *keePassGroup) subgroup(path []string) *keePassGroup {
+	for i, name := range path {
+		found := -1
+		for j := range g.Groups {
+			if g.Groups[j].Name == name {
+				found = j
+				break
+			}
+		}
+		if found < 0 {
+			g.Groups = append(g.Groups, keePassGroup{UUID: keePassGroupUUID(path[:i+1]), Name: name})
+			found = len(g.Groups) - 1
+		}
+		g = &g.Groups[found]
+	}
+	return g
+}
+
+func keePassGroupUUID(path []string) string {
+	u := uuid.NewSHA1(keePassGroupSpace, []byte(JoinGroupPath(path)))
+	return base64.StdEncoding.EncodeToString(u[:])
+}
+
+// newKeePassEntry converts a record, returning the history if it was
+// written as older versions of the entry.
+func newKeePassEntry(p *PasswordRecord) (keePassEntry, *History) {
+	u, ok := p.ID()
+	if !ok {
+		// KeePass needs one
+		u = uuid.New()
+	}
+	e := keePassEntry{UUID: base64.StdEncoding.EncodeToString(u[:])}
+	for _, s := range keePassStrings {
+		e.Strings = append(e.Strings, newKeePassString(s.key, s.typeID, p.Text(s.typeID)))
+	}
+	times := map[byte]*string{
+		CreationTime:         &e.Times.CreationTime,
+		LastModificationTime: &e.Times.LastModificationTime,
+		LastAccessTime:       &e.Times.LastAccessTime,
+		PasswordExpiryTime:   &e.Times.ExpiryTime,
+	}
+	if _, ok := p.Time(PasswordExpiryTime); ok {
+		e.Times.Expires = "True"
+	}
+
+	var history *History
+	for _, k := range p.sortedFieldKey() {
+		f := p.Fields[k]
+		standard := slices.ContainsFunc(keePassStrings, func(s keePassStandard) bool {
+			return s.typeID == k
+		})
+		if standard || k == UUID || k == Group {
+			continue
+		}
+		if t, ok := times[k]; ok {
+			if d, ok := f.Data.(uint32); ok {
+				*t = keePassTime(d)
+				continue
+			}
+		}
+		if k == PasswordHistory {
+			// only if it comes out the same, otherwise it's kept as it is
+			if h, err := ParseHistory(p.Text(k)); err == nil && h.String() == p.Text(k) && h.Enabled {
+				history = &h
+				e.History = newKeePassHistory(e.UUID, h)
+				continue
+			}
+		}
+		e.Strings = append(e.Strings, newKeePassString(FieldName(k), k, customValue(&f)))
+	}
+	return e, history
+}
+
+func newKeePassString(key string, typeID byte, value string) keePassString {
+	s := keePassString{Key: key, Value: keePassValue{Value: value}}
+	if slices.Contains(SecretFields, typeID) {
+		s.Value.ProtectInMemory = "True"
+	}
+	return s
+}
+
+// newKeePassHistory makes a version of the entry for each old password,
+// last modified when the password was changed.  The safe only keeps the old
+// passwords, so that's all they have.
+func newKeePassHistory(id string, h History) *keePassHistory {
+	var x keePassHistory
+	for _, old := range h.Entries {
+		x.Entries = append(x.Entries, keePassEntry{
+			UUID:    id,
+			Times:   keePassTimes{LastModificationTime: keePassTime(old.Changed)},
+			Strings: []keePassString{newKeePassString("Password", Password, old.Password)},
+		})
+	}
+	return &x
+}
+
+func keePassTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(XMLTimeLayout)
+}
+
+// ReadKeePassXML reads a KeePass 2.x XML export.  Custom strings named
+// after a field, as WriteKeePassXML writes them, become that field and any
+// others are added to the notes.  Entries in the recycle bin are left out.
+//
+// The top group is the database itself, so its name is only used as the
+// database name when the file doesn't have one.  If there's more than one
+// top group their names are kept as the first part of each group.
+func ReadKeePassXML(r io.Reader) (V3File, error) {
+	var f keePassFile
+	if err := xml.NewDecoder(r).Decode(&f); err != nil {
+		return V3File{}, err
+	}
+
+	var v3 V3File
+	name := f.Meta.DatabaseName
+	if name == "" && len(f.Root.Groups) == 1 {
+		name = f.Root.Groups[0].Name
+	}
+	if name != "" {
+		v3.Headers = append(v3.Headers, HeaderRecord{Type: DatabaseName, Data: name})
+	}
+	if f.Meta.DatabaseDescription != "" {
+		v3.Headers = append(v3.Headers, HeaderRecord{Type: DatabaseDescription, Data: f.Meta.DatabaseDescription})
+	}
+	historyMax := 0
+	if f.Meta.HistoryMaxItems != nil {
+		historyMax = min(*f.Meta.HistoryMaxItems, 0xff)
+	}
+
+	var read func(g *keePassGroup, path []string) error
+	read = func(g *keePassGroup, path []string) error {
+		if f.Meta.RecycleBinUUID != "" && g.UUID == f.Meta.RecycleBinUUID {
+			return nil
+		}
+		if len(g.Entries) == 0 && len(g.Groups) == 0 && len(path) > 0 {
+			v3.Headers = append(v3.Headers, HeaderRecord{Type: EmptyGroups, Data: JoinGroupPath(path)})
+		}
+		for _, e := range g.Entries {
+			p, err := e.record(path, historyMax)
+			if err != nil {
+				return err
+			}
+			v3.Passwords = append(v3.Passwords, p)
+		}
+		for i := range g.Groups {
+			if err := read(&g.Groups[i], append(slices.Clip(path), g.Groups[i].Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := range f.Root.Groups {
+		var path []string
+		if len(f.Root.Groups) > 1 {
+			path = []string{f.Root.Groups[i].Name}
+		}
+		if err := read(&f.Root.Groups[i], path); err != nil {
+			return V3File{}, err
+		}
+	}
+	return v3, nil
+}
+
+func (e *keePassEntry) record(path []string, historyMax int) (PasswordRecord, error) {
+	p := NewPasswordRecord()
+	u, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e.UUID))
+	if err == nil {
+		err = p.AddField(UUID, u)
+	}
+	if err != nil {
+		return PasswordRecord{}, fmt.Errorf("entry has a bad UUID %q: %w", e.UUID, err)
+	}
+	if len(path) > 0 {
+		p.Fields[Group] = Field{Type: Group, Data: JoinGroupPath(path)}
+	}
+
+	var extra []string
+	for _, s := range e.Strings {
+		typeID, ok := keePassFieldTypes[s.Key]
+		switch {
+		case !ok || typeID == UUID || typeID == Group:
+			if s.Value.Value != "" {
+				extra = append(extra, s.Key+": "+s.Value.Value)
+			}
+		case s.Value.Value == "":
+			// KeePass always has the standard strings, even when empty
+		default:
+			addCustomField(&p, typeID, s.Value.Value)
+		}
+	}
+	appendNotes(&p, extra)
+
+	for _, t := range []struct {
+		typeID byte
+		value  string
+	}{
+		{CreationTime, e.Times.CreationTime},
+		{LastModificationTime, e.Times.LastModificationTime},
+		{LastAccessTime, e.Times.LastAccessTime},
+	} {
+		if t.value == "" {
+			continue
+		}
+		v, err := parseKeePassTime(t.value)
+		if err != nil {
+			return PasswordRecord{}, err
+		}
+		p.Fields[t.typeID] = Field{Type: t.typeID, Data: v}
+	}
+	if strings.EqualFold(e.Times.Expires, "True") {
+		v, err := parseKeePassTime(e.Times.ExpiryTime)
+		if err != nil {
+			return PasswordRecord{}, err
+		}
+		p.Fields[PasswordExpiryTime] = Field{Type: PasswordExpiryTime, Data: v}
+	}
+
+	if e.History != nil && len(e.History.Entries) > 0 {
+		h := History{Enabled: true}
+		// KeePass keeps a version for every edit, only the ones where the
+		// password was changed afterwards are old passwords
+		next := p.Text(Password)
+		for i := len(e.History.Entries) - 1; i >= 0; i-- {
+			old := &e.History.Entries[i]
+			var password string
+			for _, s := range old.Strings {
+				if s.Key == "Password" {
+					password = s.Value.Value
+				}
+			}
+			if password == next {
+				continue
+			}
+			next = password
+			changed, err := parseKeePassTime(old.Times.LastModificationTime)
+			if err != nil {
+				return PasswordRecord{}, err
+			}
+			h.Entries = append(h.Entries, HistoryEntry{Changed: changed, Password: password})
+		}
+		slices.Reverse(h.Entries)
+		if len(h.Entries) > 0xff {
+			h.Entries = h.Entries[len(h.Entries)-0xff:]
+		}
+		h.Max = max(historyMax, len(h.Entries))
+		p.Fields[PasswordHistory] = Field{Type: PasswordHistory, Data: h.String()}
+	}
+	return p, nil
+}
+
+// the fields AddField reads as time_t values and as strings, which are
+// written as text in custom fields
+var (
+	timeFields = []byte{CreationTime, PasswordModificationTime, LastAccessTime,
+		PasswordExpiryTime, LastModificationTime}
+	textFields = []byte{Group, Autotype, CreditCardExpiration, CreditCardNumber,
+		CreditCardPIN, CreditCardVerifValue, EMailAddress, Notes,
+		OwnSymbolsForPassword, Password, PasswordHistory, PasswordPolicy,
+		PasswordPolicyName, QRCode, RunCommand, Title, URL, Username}
+)
+
+// customFieldTypes finds the field a custom field is for by its name.
+var customFieldTypes = func() map[string]byte {
+	types := make(map[string]byte)
+	for t := 0; t <= 0xff; t++ {
+		types[FieldName(byte(t))] = byte(t)
+	}
+	return types
+}()
+
+// customValue is how a field without a place of its own is written in
+// formats with custom fields.
+func customValue(f *Field) string {
+	switch d := f.Data.(type) {
+	case string:
+		return d
+	case uint32:
+		return time.Unix(int64(d), 0).UTC().Format(XMLTimeLayout)
+	}
+	return hex.EncodeToString(fieldBytes(f.Data))
+}
+
+// addCustomField is the reverse of customValue.
+func addCustomField(p *PasswordRecord, typeID byte, value string) {
+	raw := []byte(value)
+	switch {
+	case slices.Contains(textFields, typeID):
+	case slices.Contains(timeFields, typeID):
+		if t, err := parseXMLTime(value); err == nil {
+			raw = binary.LittleEndian.AppendUint32(nil, t)
+			break
+		}
+		fallthrough
+	default:
+		if b, err := hex.DecodeString(value); err == nil {
+			raw = b
+		}
+	}
+	if err := p.AddField(typeID, raw); err != nil {
+		// kept as it is, like Load does
+		p.Fields[typeID] = Field{Type: typeID, Data: raw}
+	}
+}
+
+// appendNotes adds lines to the end of the notes.
+func appendNotes(p *PasswordRecord, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	if notes := p.Text(Notes); notes != "" {
+		lines = append([]string{notes}, lines...)
+	}
+	p.Fields[Notes] = Field{Type: Notes, Data: strings.Join(lines, "\n")}
+}
+
+// parseKeePassTime reads a time as text, or as KDBX 4 writes them, the
+// base64 of the little endian number of seconds since 0001-01-01.
+func parseKeePassTime(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if t, err := parseXMLTime(s); err == nil {
+		return t, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return uint32(int64(binary.LittleEndian.Uint64(b)) - keePassEpoch), nil
+}
@@ -0,0 +1,199 @@
+package pwsafe_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestKeePassXMLRoundTrip(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass <&> word")
+	for k, v := range map[byte]interface{}{
+		pwsafe.Group:                    `web.example\.com`,
+		pwsafe.Notes:                    "line 1\nline 2",
+		pwsafe.URL:                      "https://example.com/?a=1&b=2",
+		pwsafe.CreationTime:             uint32(1600000000),
+		pwsafe.LastModificationTime:     uint32(1600000100),
+		pwsafe.PasswordExpiryTime:       uint32(1700000000),
+		pwsafe.PasswordModificationTime: uint32(1600000050),
+		pwsafe.PasswordHistory:          "10302" + "5f5e1000" + "0003" + "old" + "5f5e1001" + "0005" + "older",
+		pwsafe.TwoFactorKey:             []byte{0, 1, 2, 0xff},
+		pwsafe.CreditCardNumber:         "4111111111111111",
+		pwsafe.EMailAddress:             "user@example.com",
+	} {
+		r.Fields[k] = pwsafe.Field{Type: k, Data: v}
+	}
+	disabled := record(uuid.New(), "no history", "user", "pass")
+	disabled.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "00300"}
+
+	v3 := pwsafe.V3File{
+		Headers: []pwsafe.HeaderRecord{
+			{Type: pwsafe.DatabaseName, Data: "test"},
+			{Type: pwsafe.DatabaseDescription, Data: "for testing"},
+			{Type: pwsafe.EmptyGroups, Data: "web.empty"},
+			{Type: pwsafe.EmptyGroups, Data: "empty"},
+		},
+		// records in the top group come first
+		Passwords: []pwsafe.PasswordRecord{disabled, r},
+	}
+
+	var b bytes.Buffer
+	if err := pwsafe.WriteKeePassXML(&b, &v3); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<Name>example.com</Name>",
+		"<CreationTime>2020-09-13T12:26:40Z</CreationTime>",
+		`<Value ProtectInMemory="True">pass &lt;&amp;&gt; word</Value>`,
+		"<Key>EMailAddress</Key>",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected %s in:\n%s", want, b.String())
+		}
+	}
+	// the history only has the old passwords
+	if n := strings.Count(b.String(), "<Key>Title</Key>"); n != 2 {
+		t.Errorf("Expected a title for each entry and none in the history, got %d", n)
+	}
+	read, err := pwsafe.ReadKeePassXML(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(v3, read); diff != "" {
+		t.Errorf("Round trip not identical (-wrote +read):\n%s\n", diff)
+	}
+}
+
+func TestReadKeePassXML(t *testing.T) {
+	// roughly what KeePassXC exports
+	x := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePassXC</Generator>
+		<DatabaseName>Passwords</DatabaseName>
+		<HistoryMaxItems>10</HistoryMaxItems>
+		<RecycleBinUUID>AAAAAAAAAAAAAAAAAAAAAQ==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>AAAAAAAAAAAAAAAAAAAAAA==</UUID>
+			<Name>Passwords</Name>
+			<Entry>
+				<UUID>X/4HMa6QTLCz19tAhV2M6w==</UUID>
+				<Times>
+					<CreationTime>2020-09-13T12:26:40Z</CreationTime>
+					<ExpiryTime>2020-09-13T12:26:40Z</ExpiryTime>
+					<Expires>False</Expires>
+				</Times>
+				<String><Key>Title</Key><Value>bank</Value></String>
+				<String><Key>UserName</Key><Value/></String>
+				<String><Key>Password</Key><Value ProtectInMemory="True">secret</Value></String>
+				<String><Key>Recovery</Key><Value>code</Value></String>
+				<History>
+					<Entry>
+						<UUID>X/4HMa6QTLCz19tAhV2M6w==</UUID>
+						<Times><LastModificationTime>AAfw1g4AAAA=</LastModificationTime></Times>
+						<String><Key>Password</Key><Value ProtectInMemory="True">old</Value></String>
+					</Entry>
+					<Entry>
+						<UUID>X/4HMa6QTLCz19tAhV2M6w==</UUID>
+						<Times><LastModificationTime>EBXw1g4AAAA=</LastModificationTime></Times>
+						<String><Key>Title</Key><Value>bank</Value></String>
+						<String><Key>Password</Key><Value ProtectInMemory="True">secret</Value></String>
+						<String><Key>Notes</Key><Value>only the notes changed</Value></String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>AAAAAAAAAAAAAAAAAAAAAQ==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>AAAAAAAAAAAAAAAAAAAAAg==</UUID>
+					<String><Key>Title</Key><Value>deleted</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+	read, err := pwsafe.ReadKeePassXML(strings.NewReader(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := pwsafe.NewPasswordRecord()
+	for k, v := range map[byte]interface{}{
+		pwsafe.UUID:            uuid.MustParse("5ffe0731-ae90-4cb0-b3d7-db40855d8ceb"),
+		pwsafe.Title:           "bank",
+		pwsafe.Password:        "secret",
+		pwsafe.Notes:           "Recovery: code",
+		pwsafe.CreationTime:    uint32(1600000000),
+		pwsafe.PasswordHistory: "10a01" + "5f5e1000" + "0003" + "old",
+	} {
+		p.Fields[k] = pwsafe.Field{Type: k, Data: v}
+	}
+	expected := pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.DatabaseName, Data: "Passwords"}},
+		Passwords: []pwsafe.PasswordRecord{p},
+	}
+	if diff := cmp.Diff(expected, read); diff != "" {
+		t.Errorf("Unexpected safe (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestReadKeePassXMLTopGroups(t *testing.T) {
+	entry := func(id, title string) string {
+		return "<Entry><UUID>" + id + "</UUID><String><Key>Title</Key><Value>" + title + "</Value></String></Entry>"
+	}
+	tests := []struct {
+		name     string
+		meta     string
+		groups   string
+		expected []pwsafe.HeaderRecord
+		group    string
+	}{
+		{
+			name:     "the top group names the database",
+			groups:   "<Group><Name>Passwords</Name>" + entry("X/4HMa6QTLCz19tAhV2M6w==", "bank") + "</Group>",
+			expected: []pwsafe.HeaderRecord{{Type: pwsafe.DatabaseName, Data: "Passwords"}},
+		},
+		{
+			name:     "the database name wins",
+			meta:     "<DatabaseName>Mine</DatabaseName>",
+			groups:   "<Group><Name>Passwords</Name>" + entry("X/4HMa6QTLCz19tAhV2M6w==", "bank") + "</Group>",
+			expected: []pwsafe.HeaderRecord{{Type: pwsafe.DatabaseName, Data: "Mine"}},
+		},
+		{
+			name: "more than one top group",
+			groups: "<Group><Name>Work</Name>" + entry("X/4HMa6QTLCz19tAhV2M6w==", "bank") + "</Group>" +
+				"<Group><Name>Home</Name></Group>",
+			expected: []pwsafe.HeaderRecord{{Type: pwsafe.EmptyGroups, Data: "Home"}},
+			group:    "Work",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x := "<KeePassFile><Meta>" + test.meta + "</Meta><Root>" + test.groups + "</Root></KeePassFile>"
+			read, err := pwsafe.ReadKeePassXML(strings.NewReader(x))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, read.Headers); diff != "" {
+				t.Errorf("Unexpected headers (-want +got):\n%s\n", diff)
+			}
+			if len(read.Passwords) != 1 || read.Passwords[0].Text(pwsafe.Group) != test.group {
+				t.Errorf("Expected the record in group %q, got %+v", test.group, read.Passwords)
+			}
+		})
+	}
+}
+
+func TestReadKeePassXMLBadUUID(t *testing.T) {
+	x := "<KeePassFile><Root><Group><Entry><UUID>AAAA</UUID></Entry></Group></Root></KeePassFile>"
+	if _, err := pwsafe.ReadKeePassXML(strings.NewReader(x)); err == nil {
+		t.Error("Expected an error for a UUID that isn't 16 bytes")
+	}
+}
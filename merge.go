@@ -0,0 +1,169 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergeWinner decides which version of a record is kept when two safes
+// being merged have different records with the same UUID.
+type MergeWinner int
+
+const (
+	// WinnerNewest keeps the record with the latest LastModificationTime,
+	// or the left one if that doesn't decide it.
+	WinnerNewest MergeWinner = iota
+	WinnerLeft
+	WinnerRight
+	// WinnerAsk calls MergeOptions.Ask.
+	WinnerAsk
+)
+
+var mergeWinnerNames = []string{
+	WinnerNewest: "newest",
+	WinnerLeft:   "left",
+	WinnerRight:  "right",
+	WinnerAsk:    "ask",
+}
+
+func (w MergeWinner) String() string {
+	if w < 0 || int(w) >= len(mergeWinnerNames) {
+		return fmt.Sprintf("MergeWinner(%d)", int(w))
+	}
+	return mergeWinnerNames[w]
+}
+
+// MergeWinnerNames lists the names accepted by ParseMergeWinner.
+func MergeWinnerNames() []string {
+	return append([]string(nil), mergeWinnerNames...)
+}
+
+func ParseMergeWinner(name string) (MergeWinner, error) {
+	for i, n := range mergeWinnerNames {
+		if n == name {
+			return MergeWinner(i), nil
+		}
+	}
+	return WinnerNewest, fmt.Errorf("unknown merge winner %q, expected one of %s",
+		name, strings.Join(mergeWinnerNames, ", "))
+}
+
+type MergeOptions struct {
+	Winner MergeWinner
+	// Ask is called for each conflict when Winner is WinnerAsk.  It returns
+	// true to keep the left record.
+	Ask func(c *MergeConflict) (bool, error)
+}
+
+// MergeConflict is a record that's different in the two safes.
+type MergeConflict struct {
+	Left, Right PasswordRecord
+	Fields      []FieldChange
+	LeftWon     bool
+	// the winner, with the password histories of both combined
+	Kept PasswordRecord
+}
+
+type MergeResult struct {
+	Passwords []PasswordRecord
+	// records from the right that weren't in the left
+	Added     []PasswordRecord
+	Conflicts []MergeConflict
+}
+
+// Merge adds the records from the right safe to those in the left.  Records
+// are matched by UUID and when they differ the winner is picked according to
+// the options.  Records identical to one already there aren't added again.
+func Merge(left, right *V3File, opts MergeOptions) (MergeResult, error) {
+	var result MergeResult
+	result.Passwords = append(result.Passwords, left.Passwords...)
+
+	byUUID := make(map[string]int)
+	seen := make(map[[32]byte]bool)
+	for i := range result.Passwords {
+		p := &result.Passwords[i]
+		if id, ok := p.ID(); ok {
+			if _, dup := byUUID[id.String()]; !dup {
+				byUUID[id.String()] = i
+			}
+		}
+		seen[p.Sha256()] = true
+	}
+
+	// where the records added from the right are in Added
+	added := make(map[int]int)
+	for _, r := range right.Passwords {
+		if seen[r.Sha256()] {
+			continue
+		}
+		id, hasID := r.ID()
+		i, ok := -1, false
+		if hasID {
+			i, ok = byUUID[id.String()]
+		}
+		if !ok {
+			result.Passwords = append(result.Passwords, r)
+			result.Added = append(result.Added, r)
+			seen[r.Sha256()] = true
+			if hasID {
+				// a different record further on with the same UUID is a
+				// conflict with this one
+				byUUID[id.String()] = len(result.Passwords) - 1
+				added[len(result.Passwords)-1] = len(result.Added) - 1
+			}
+			continue
+		}
+
+		l := result.Passwords[i]
+		c := MergeConflict{Left: l, Right: r, Fields: DiffRecords(&l, &r)}
+		switch opts.Winner {
+		case WinnerNewest:
+			c.LeftWon = !newer(&r, &l, LastModificationTime)
+		case WinnerLeft:
+			c.LeftWon = true
+		case WinnerAsk:
+			if opts.Ask == nil {
+				return MergeResult{}, fmt.Errorf("no way to ask which record to keep")
+			}
+			var err error
+			if c.LeftWon, err = opts.Ask(&c); err != nil {
+				return MergeResult{}, err
+			}
+		}
+		if c.LeftWon {
+			c.Kept = withHistory(l, r)
+		} else {
+			c.Kept = withHistory(r, l)
+		}
+		result.Passwords[i] = c.Kept
+		if a, ok := added[i]; ok {
+			result.Added[a] = c.Kept
+		}
+		seen[c.Kept.Sha256()] = true
+		result.Conflicts = append(result.Conflicts, c)
+	}
+	return result, nil
+}
+
+// withHistory returns a copy of the winner with the password history of the
+// loser added to its own.
+func withHistory(winner, loser PasswordRecord) PasswordRecord {
+	l, ok := loser.Fields[PasswordHistory]
+	if !ok {
+		return winner
+	}
+	merged := NewPasswordRecord()
+	for k, f := range winner.Fields {
+		merged.Fields[k] = f
+	}
+	if _, ok := winner.Fields[PasswordHistory]; !ok {
+		merged.Fields[PasswordHistory] = l
+		return merged
+	}
+	a, errA := ParseHistory(winner.Text(PasswordHistory))
+	b, errB := ParseHistory(loser.Text(PasswordHistory))
+	if errA == nil && errB == nil {
+		merged.Fields[PasswordHistory] = Field{Type: PasswordHistory, Data: MergeHistory(a, b).String()}
+	}
+	return merged
+}
@@ -0,0 +1,204 @@
+package pwsafe
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Merge3Options struct {
+	// add the theirs version of a record with conflicting fields as a copy,
+	// titled the way the desktop client titles its merge conflicts, with
+	// "-merged" and the time as MMDDYY-HHMMSS
+	ConflictEntries bool
+}
+
+// FieldConflict is a field changed differently on both sides.  Secrets
+// don't have their values filled in.
+type FieldConflict struct {
+	Type                   byte
+	Secret                 bool
+	Ancestor, Ours, Theirs string
+}
+
+func (c FieldConflict) String() string {
+	if c.Secret {
+		return FieldName(c.Type) + ": changed on both sides"
+	}
+	return fmt.Sprintf("%s: %q -> ours %q, theirs %q", FieldName(c.Type), c.Ancestor, c.Ours, c.Theirs)
+}
+
+// Merge3Conflict is a record that couldn't be merged cleanly.
+type Merge3Conflict struct {
+	// the record in the result, for conflicting fields it has our value
+	Record PasswordRecord
+	Reason string
+	Fields []FieldConflict
+	// the conflict entry added with their version, if any
+	Copy *PasswordRecord
+}
+
+type Merge3Result struct {
+	Passwords []PasswordRecord
+	Conflicts []Merge3Conflict
+}
+
+// Merge3 merges the changes made in ours and theirs since the ancestor.
+// Records are matched by UUID, and fields changed on only one side take that
+// side's value.  When both sides change a field differently it's a conflict,
+// except for modification and access times where the latest wins, creation
+// times where the earliest wins and password histories which are combined.
+// A record deleted on one side and changed on the other is kept.
+func Merge3(ancestor, ours, theirs *V3File, opts Merge3Options) Merge3Result {
+	base := firstByUUID(ancestor.Passwords)
+	theirIDs := firstByUUID(theirs.Passwords)
+
+	var result Merge3Result
+	done := make(map[uuid.UUID]bool)
+	ourRecords := make(map[[32]byte]bool)
+	for _, o := range ours.Passwords {
+		ourRecords[o.Sha256()] = true
+		id, ok := o.ID()
+		if !ok || done[id] {
+			result.Passwords = append(result.Passwords, o)
+			continue
+		}
+		done[id] = true
+		b, inBase := base[id]
+		t, inTheirs := theirIDs[id]
+		switch {
+		case !inTheirs && !inBase:
+			// added by us
+			result.Passwords = append(result.Passwords, o)
+		case !inTheirs:
+			if o.Sha256() != b.Sha256() {
+				result.Passwords = append(result.Passwords, o)
+				result.Conflicts = append(result.Conflicts, Merge3Conflict{Record: o, Reason: "changed in ours, deleted in theirs"})
+			}
+		default:
+			if !inBase {
+				b = NewPasswordRecord()
+			}
+			merged, conflicts := merge3Record(&b, &o, &t)
+			result.Passwords = append(result.Passwords, merged)
+			if len(conflicts) == 0 {
+				continue
+			}
+			c := Merge3Conflict{Record: merged, Reason: "changed on both sides", Fields: conflicts}
+			if opts.ConflictEntries {
+				entry := withUUID(t, uuid.New())
+				entry.Fields[Title] = Field{Type: Title, Data: fmt.Sprintf("%s -merged %s", t.Text(Title), time.Now().Format("010206-150405"))}
+				result.Passwords = append(result.Passwords, entry)
+				c.Copy = &entry
+			}
+			result.Conflicts = append(result.Conflicts, c)
+		}
+	}
+
+	for _, t := range theirs.Passwords {
+		id, ok := t.ID()
+		if !ok {
+			if !ourRecords[t.Sha256()] {
+				result.Passwords = append(result.Passwords, t)
+			}
+			continue
+		}
+		if done[id] {
+			continue
+		}
+		done[id] = true
+		b, inBase := base[id]
+		switch {
+		case !inBase:
+			// added by them
+			result.Passwords = append(result.Passwords, t)
+		case t.Sha256() != b.Sha256():
+			result.Passwords = append(result.Passwords, t)
+			result.Conflicts = append(result.Conflicts, Merge3Conflict{Record: t, Reason: "deleted in ours, changed in theirs"})
+		}
+	}
+	return result
+}
+
+func firstByUUID(passwords []PasswordRecord) map[uuid.UUID]PasswordRecord {
+	m := make(map[uuid.UUID]PasswordRecord)
+	for _, p := range passwords {
+		if id, ok := p.ID(); ok {
+			if _, seen := m[id]; !seen {
+				m[id] = p
+			}
+		}
+	}
+	return m
+}
+
+// merge3Record merges the fields of a record.  Conflicting fields keep our
+// value.
+func merge3Record(b, o, t *PasswordRecord) (PasswordRecord, []FieldConflict) {
+	types := make(map[byte]bool)
+	for _, p := range []*PasswordRecord{b, o, t} {
+		for k := range p.Fields {
+			types[k] = true
+		}
+	}
+	var keys []byte
+	for k := range types {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	merged := NewPasswordRecord()
+	var conflicts []FieldConflict
+	for _, k := range keys {
+		bv, ov, tv := fieldState(b, k), fieldState(o, k), fieldState(t, k)
+		from := o
+		switch {
+		case ov == tv, tv == bv:
+		case ov == bv:
+			from = t
+		default:
+			switch k {
+			case LastModificationTime, LastAccessTime, PasswordModificationTime:
+				if newer(t, o, k) {
+					from = t
+				}
+			case CreationTime:
+				if older(t, o, k) {
+					from = t
+				}
+			case PasswordHistory:
+				merged.Fields[k] = withHistory(*o, *t).Fields[k]
+				continue
+			default:
+				c := FieldConflict{Type: k, Secret: slices.Contains(SecretFields, k)}
+				if !c.Secret {
+					c.Ancestor, c.Ours, c.Theirs = b.Display(k, time.DateTime), o.Display(k, time.DateTime), t.Display(k, time.DateTime)
+				}
+				conflicts = append(conflicts, c)
+			}
+		}
+		if f, ok := from.Fields[k]; ok {
+			merged.Fields[k] = f
+		}
+	}
+	return merged, conflicts
+}
+
+// fieldState is a field's value, distinguishing a missing field from an
+// empty one.
+func fieldState(p *PasswordRecord, typeID byte) string {
+	f, ok := p.Fields[typeID]
+	if !ok {
+		return "missing"
+	}
+	return "set " + f.String()
+}
+
+func older(a, b *PasswordRecord, typeID byte) bool {
+	at, aok := a.Time(typeID)
+	bt, bok := b.Time(typeID)
+	return aok && (!bok || at < bt)
+}
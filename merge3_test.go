@@ -0,0 +1,94 @@
+package pwsafe_test
+
+import (
+	"regexp"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// edit returns a copy of the record with a field set, or removed if the
+// value is nil.
+func edit(p pwsafe.PasswordRecord, typeID byte, value interface{}) pwsafe.PasswordRecord {
+	r := pwsafe.NewPasswordRecord()
+	for k, f := range p.Fields {
+		r.Fields[k] = f
+	}
+	if value == nil {
+		delete(r.Fields, typeID)
+	} else {
+		r.Fields[typeID] = pwsafe.Field{Type: typeID, Data: value}
+	}
+	return r
+}
+
+func TestMerge3(t *testing.T) {
+	clean := record(uuid.New(), "clean", "user", "pass")
+	clean = edit(clean, pwsafe.Notes, "notes")
+	deleted := record(uuid.New(), "deleted", "user", "pass")
+	deletedChanged := record(uuid.New(), "deleted and changed", "user", "pass")
+	conflict := record(uuid.New(), "conflict", "user", "pass")
+	ancestor := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{clean, deleted, deletedChanged, conflict}}
+
+	// we remove the notes and they change the title, so both apply
+	ourClean := edit(edit(clean, pwsafe.Notes, nil), pwsafe.LastModificationTime, uint32(100))
+	theirClean := edit(edit(clean, pwsafe.Title, "renamed"), pwsafe.LastModificationTime, uint32(200))
+	ourConflict := edit(conflict, pwsafe.Password, "ours")
+	theirConflict := edit(edit(conflict, pwsafe.Password, "theirs"), pwsafe.URL, "https://example.com")
+	ourAdded := record(uuid.New(), "ours", "user", "pass")
+	theirAdded := record(uuid.New(), "theirs", "user", "pass")
+	theirDeletedChanged := edit(deletedChanged, pwsafe.Username, "changed")
+
+	// we delete both, they leave one alone and change the other
+	ours := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{ourClean, ourConflict, ourAdded}}
+	theirs := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{theirAdded, deleted, theirDeletedChanged, theirConflict, theirClean}}
+
+	result := pwsafe.Merge3(&ancestor, &ours, &theirs, pwsafe.Merge3Options{})
+
+	mergedClean := edit(theirClean, pwsafe.Notes, nil)
+	mergedConflict := edit(ourConflict, pwsafe.URL, "https://example.com")
+	expected := []pwsafe.PasswordRecord{mergedClean, mergedConflict, ourAdded, theirAdded, theirDeletedChanged}
+	if diff := cmp.Diff(expected, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+
+	expectedConflicts := []pwsafe.Merge3Conflict{
+		{
+			Record: mergedConflict,
+			Reason: "changed on both sides",
+			Fields: []pwsafe.FieldConflict{{Type: pwsafe.Password, Secret: true}},
+		},
+		{Record: theirDeletedChanged, Reason: "deleted in ours, changed in theirs"},
+	}
+	if diff := cmp.Diff(expectedConflicts, result.Conflicts); diff != "" {
+		t.Errorf("Unexpected conflicts (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestMerge3ConflictEntries(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass")
+	ancestor := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{r}}
+	ours := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{edit(r, pwsafe.Username, "ours")}}
+	theirs := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{edit(r, pwsafe.Username, "theirs")}}
+
+	result := pwsafe.Merge3(&ancestor, &ours, &theirs, pwsafe.Merge3Options{ConflictEntries: true})
+
+	if len(result.Passwords) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(result.Passwords))
+	}
+	entry := result.Passwords[1]
+	if !regexp.MustCompile(`^title -merged \d{6}-\d{6}$`).MatchString(entry.Text(pwsafe.Title)) {
+		t.Errorf("Expected the title to have the client's merge suffix, got %q", entry.Text(pwsafe.Title))
+	}
+	if !pwsafe.IsConflictCopy(&entry) || entry.Text(pwsafe.Username) != "theirs" {
+		t.Errorf("Expected a conflict entry with their username, got %s", entry.String())
+	}
+	if id, _ := entry.ID(); id == result.Conflicts[0].Record.Fields[pwsafe.UUID].Data {
+		t.Error("Expected the conflict entry to have a new UUID")
+	}
+	if diff := cmp.Diff([]pwsafe.FieldConflict{{Type: pwsafe.Username, Ours: "ours", Theirs: "theirs", Ancestor: "user"}}, result.Conflicts[0].Fields); diff != "" {
+		t.Errorf("Unexpected fields (-want +got):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,117 @@
+package pwsafe_test
+
+import (
+	"errors"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestMerge(t *testing.T) {
+	same := record(uuid.New(), "same", "user", "pass")
+	older := record(uuid.New(), "changed", "user", "old")
+	older.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(100)}
+	older.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "10201" + "00000001" + "0005" + "older"}
+	newer := record(uuid.UUID{}, "changed", "user", "new")
+	newer.Fields[pwsafe.UUID] = older.Fields[pwsafe.UUID]
+	newer.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(200)}
+	newer.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "10201" + "00000002" + "0003" + "old"}
+	added := record(uuid.New(), "added", "user", "pass")
+
+	left := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{same, older}}
+	right := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{added, newer, same, added}}
+
+	mergedHistory := "10202" + "00000001" + "0005" + "older" + "00000002" + "0003" + "old"
+	tests := []struct {
+		name   string
+		winner pwsafe.MergeWinner
+		kept   pwsafe.PasswordRecord
+	}{
+		{"newest", pwsafe.WinnerNewest, newer},
+		{"left", pwsafe.WinnerLeft, older},
+		{"right", pwsafe.WinnerRight, newer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := pwsafe.Merge(&left, &right, pwsafe.MergeOptions{Winner: tt.winner})
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := pwsafe.NewPasswordRecord()
+			for k, f := range tt.kept.Fields {
+				kept.Fields[k] = f
+			}
+			kept.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: mergedHistory}
+
+			if diff := cmp.Diff([]pwsafe.PasswordRecord{same, kept, added}, result.Passwords); diff != "" {
+				t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+			}
+			if diff := cmp.Diff([]pwsafe.PasswordRecord{added}, result.Added); diff != "" {
+				t.Errorf("Unexpected added (-want +got):\n%s\n", diff)
+			}
+			if len(result.Conflicts) != 1 {
+				t.Fatalf("Expected 1 conflict, got %d", len(result.Conflicts))
+			}
+		})
+	}
+}
+
+func TestMergeAsk(t *testing.T) {
+	a := record(uuid.New(), "title", "user", "left")
+	b := record(uuid.UUID{}, "title", "user", "right")
+	b.Fields[pwsafe.UUID] = a.Fields[pwsafe.UUID]
+	left := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{a}}
+	right := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{b}}
+
+	asked := 0
+	result, err := pwsafe.Merge(&left, &right, pwsafe.MergeOptions{
+		Winner: pwsafe.WinnerAsk,
+		Ask: func(c *pwsafe.MergeConflict) (bool, error) {
+			asked++
+			return false, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asked != 1 {
+		t.Errorf("Expected to be asked once, asked %d times", asked)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{b}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+
+	quit := errors.New("quit")
+	_, err = pwsafe.Merge(&left, &right, pwsafe.MergeOptions{
+		Winner: pwsafe.WinnerAsk,
+		Ask:    func(c *pwsafe.MergeConflict) (bool, error) { return false, quit },
+	})
+	if !errors.Is(err, quit) {
+		t.Errorf("Expected the error from Ask, got %v", err)
+	}
+}
+
+func TestMergeRightUUIDConflict(t *testing.T) {
+	a := record(uuid.New(), "title", "user", "left")
+	first := record(uuid.New(), "added", "user", "first")
+	second := record(uuid.UUID{}, "added", "user", "second")
+	second.Fields[pwsafe.UUID] = first.Fields[pwsafe.UUID]
+	left := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{a}}
+	right := pwsafe.V3File{Passwords: []pwsafe.PasswordRecord{first, second}}
+
+	result, err := pwsafe.Merge(&left, &right, pwsafe.MergeOptions{Winner: pwsafe.WinnerRight})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, second}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{second}, result.Added); diff != "" {
+		t.Errorf("Unexpected added (-want +got):\n%s\n", diff)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Errorf("Expected 1 conflict, got %d", len(result.Conflicts))
+	}
+}
@@ -0,0 +1,152 @@
+package pwsafe
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MergedTitleSuffix matches the suffix the desktop client adds to the title
+// of an entry that conflicted with an existing one while merging, "-merged"
+// and the time as MMDDYY-HHMMSS.
+var MergedTitleSuffix = regexp.MustCompile(`\s*-merged \d{6}-\d{6}$`)
+
+// MergeGroup matches the name of the top level group the client puts merged
+// entries in, "Merged" and the time as MMDDYY-HHMMSS.
+var MergeGroup = regexp.MustCompile(`^Merged \d{6}-\d{6}$`)
+
+// ConflictCopy pairs a merge conflict copy with the record it was copied
+// from.
+type ConflictCopy struct {
+	Copy     PasswordRecord
+	Original PasswordRecord
+}
+
+type ConflictCopyResult struct {
+	// the records to write out, with the collapsed copies removed
+	Passwords []PasswordRecord
+	// copies with the same secrets as their original, these have been
+	// merged into the original
+	Collapsed []ConflictCopy
+	// copies whose secrets differ from their original and need looking at
+	Differing []ConflictCopy
+	// copies with no original
+	Unmatched []PasswordRecord
+	// copies that match more than one original, these are left for review
+	Ambiguous []AmbiguousCopy
+}
+
+// AmbiguousCopy is a merge conflict copy that could have come from any of
+// the candidates.
+type AmbiguousCopy struct {
+	Copy       PasswordRecord
+	Candidates []PasswordRecord
+}
+
+// IsConflictCopy reports whether the record looks like a copy made by the
+// desktop client when merging.
+func IsConflictCopy(p *PasswordRecord) bool {
+	if MergedTitleSuffix.MatchString(p.Text(Title)) {
+		return true
+	}
+	_, inGroup := mergeGroupRest(p)
+	return inGroup
+}
+
+// mergeGroupRest returns the group without the merge group at the top, and
+// whether it was there.
+func mergeGroupRest(p *PasswordRecord) (string, bool) {
+	path := SplitGroupPath(p.Text(Group))
+	if len(path) == 0 || !MergeGroup.MatchString(path[0]) {
+		return p.Text(Group), false
+	}
+	return JoinGroupPath(path[1:]), true
+}
+
+// CollapseConflictCopies finds the merge conflict copies and matches them
+// to their originals by the group without the merge group, the title without
+// the merge suffix, the username and the URL.  Copies with identical secrets
+// are merged into their original and dropped.  Copies that match more than
+// one original are left alone.
+func CollapseConflictCopies(passwords []PasswordRecord) ConflictCopyResult {
+	originals := make(map[string][]int)
+	for i := range passwords {
+		p := &passwords[i]
+		if !IsConflictCopy(p) {
+			key := originalKey(p)
+			originals[key] = append(originals[key], i)
+		}
+	}
+
+	// merge into a copy so the originals in the result are the untouched
+	// records
+	collapsed := make([]PasswordRecord, len(passwords))
+	copy(collapsed, passwords)
+	drop := make(map[int]bool)
+
+	var result ConflictCopyResult
+	for i := range passwords {
+		p := &passwords[i]
+		if !IsConflictCopy(p) {
+			continue
+		}
+		candidates := originals[originalKey(p)]
+		switch len(candidates) {
+		case 0:
+			result.Unmatched = append(result.Unmatched, *p)
+			continue
+		case 1:
+		default:
+			ambiguous := AmbiguousCopy{Copy: *p}
+			for _, c := range candidates {
+				ambiguous.Candidates = append(ambiguous.Candidates, passwords[c])
+			}
+			result.Ambiguous = append(result.Ambiguous, ambiguous)
+			continue
+		}
+		match := candidates[0]
+		if !sameSecrets(p, &passwords[match]) {
+			result.Differing = append(result.Differing,
+				ConflictCopy{Copy: *p, Original: passwords[match]})
+			continue
+		}
+		result.Collapsed = append(result.Collapsed,
+			ConflictCopy{Copy: *p, Original: passwords[match]})
+		// the copy's title and group are artifacts of the merge
+		extra := NewPasswordRecord()
+		for k, v := range p.Fields {
+			if k != Title && k != Group {
+				extra.Fields[k] = v
+			}
+		}
+		collapsed[match], _ = MergeRecords([]PasswordRecord{collapsed[match], extra}, 0, DefaultNotesSeparator)
+		drop[i] = true
+	}
+
+	for i := range collapsed {
+		if !drop[i] {
+			result.Passwords = append(result.Passwords, collapsed[i])
+		}
+	}
+	return result
+}
+
+func originalKey(p *PasswordRecord) string {
+	group, _ := mergeGroupRest(p)
+	title := MergedTitleSuffix.ReplaceAllString(p.Text(Title), "")
+	return strings.Join([]string{group, title, p.Text(Username), p.Text(URL)}, "\x00")
+}
+
+func sameSecrets(a, b *PasswordRecord) bool {
+	for _, k := range SecretFields {
+		// history is unioned when merging so doesn't need to match
+		if k == PasswordHistory {
+			continue
+		}
+		fa, aok := a.Fields[k]
+		fb, bok := b.Fields[k]
+		if aok != bok || (aok && fa.String() != fb.String()) {
+			return false
+		}
+	}
+	return true
+}
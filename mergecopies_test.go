@@ -0,0 +1,61 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestCollapseConflictCopies(t *testing.T) {
+	original := record(uuid.New(), "bank", "user", "pass")
+	same := record(uuid.New(), "bank -merged 010221-101010", "user", "pass")
+	same.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "notes"}
+	differs := record(uuid.New(), "bank -merged 010221-111111", "user", "changed")
+	inGroup := record(uuid.New(), "bank", "user", "pass")
+	inGroup.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "Merged 010221-101010"}
+	orphan := record(uuid.New(), "shop -merged 010221-101010", "user", "pass")
+	other := record(uuid.New(), "shop", "someone", "pass")
+	// a group of the user's own that happens to start with merge
+	own := record(uuid.New(), "bank", "user", "pass")
+	own.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "Merge requests"}
+	// the original has to be in the same group
+	otherGroup := record(uuid.New(), "bank -merged 010221-101010", "user", "pass")
+	otherGroup.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "work"}
+	twin := record(uuid.New(), "mail", "user", "pass")
+	twin.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "home"}
+	twin2 := record(uuid.New(), "mail", "user", "pass")
+	twin2.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "home"}
+	ambiguous := record(uuid.New(), "mail", "user", "pass")
+	ambiguous.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "Merged 010221-101010.home"}
+
+	result := pwsafe.CollapseConflictCopies([]pwsafe.PasswordRecord{
+		original, same, differs, inGroup, orphan, other, own, otherGroup, twin, twin2, ambiguous,
+	})
+
+	merged := record(uuid.Nil, "bank", "user", "pass")
+	merged.Fields[pwsafe.UUID] = original.Fields[pwsafe.UUID]
+	merged.Fields[pwsafe.Notes] = same.Fields[pwsafe.Notes]
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{merged, differs, orphan, other, own, otherGroup, twin, twin2, ambiguous}, result.Passwords); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+	expected := []pwsafe.ConflictCopy{
+		{Copy: same, Original: original},
+		{Copy: inGroup, Original: original},
+	}
+	if diff := cmp.Diff(expected, result.Collapsed); diff != "" {
+		t.Errorf("Unexpected collapsed (-want +got):\n%s\n", diff)
+	}
+	expected = []pwsafe.ConflictCopy{{Copy: differs, Original: original}}
+	if diff := cmp.Diff(expected, result.Differing); diff != "" {
+		t.Errorf("Unexpected differing (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{orphan, otherGroup}, result.Unmatched); diff != "" {
+		t.Errorf("Unexpected unmatched (-want +got):\n%s\n", diff)
+	}
+	expectedAmbiguous := []pwsafe.AmbiguousCopy{{Copy: ambiguous, Candidates: []pwsafe.PasswordRecord{twin, twin2}}}
+	if diff := cmp.Diff(expectedAmbiguous, result.Ambiguous); diff != "" {
+		t.Errorf("Unexpected ambiguous (-want +got):\n%s\n", diff)
+	}
+}
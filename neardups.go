@@ -0,0 +1,184 @@
+package pwsafe
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultNearDuplicateThreshold is the similarity score above which records
+// are treated as near duplicates.
+const DefaultNearDuplicateThreshold = 0.85
+
+// NearDuplicates is a group of records that are similar to each other.
+type NearDuplicates struct {
+	Records []PasswordRecord
+	// the positions of the records in the list that was searched
+	Indexes []int
+	// the lowest of the scores that linked the records together
+	Score float64
+}
+
+// how much each field counts towards the similarity of two records.
+var similarityWeights = []struct {
+	Type   byte
+	Weight float64
+}{
+	{Password, 4},
+	{Title, 3},
+	{Username, 3},
+	{URL, 3},
+	{Group, 1},
+	{Notes, 1},
+}
+
+// Normalise cleans up a field value so that insignificant differences are
+// ignored.  Whitespace is trimmed, usernames are lower cased and URLs lose
+// their http or https scheme, their case and any trailing slash.  Passwords
+// are left alone.
+func Normalise(typeID byte, value string) string {
+	if typeID == Password {
+		return value
+	}
+	value = strings.TrimSpace(value)
+	switch typeID {
+	case Username, EMailAddress:
+		value = strings.ToLower(value)
+	case URL:
+		value = strings.ToLower(value)
+		for _, scheme := range []string{"https://", "http://"} {
+			value = strings.TrimPrefix(value, scheme)
+		}
+		value = strings.TrimRight(value, "/")
+	}
+	return value
+}
+
+// Similarity scores how alike two records are between 0 and 1.  Only the
+// fields in similarityWeights are compared and the password has to match
+// exactly to count.
+func Similarity(a, b *PasswordRecord) float64 {
+	var score, total float64
+	for _, w := range similarityWeights {
+		k, weight := w.Type, w.Weight
+		va := Normalise(k, a.Text(k))
+		vb := Normalise(k, b.Text(k))
+		if va == "" && vb == "" {
+			continue
+		}
+		total += weight
+		if k == Password {
+			if va == vb {
+				score += weight
+			}
+			continue
+		}
+		score += weight * textSimilarity(va, vb)
+	}
+	if total == 0 {
+		return 0
+	}
+	return score / total
+}
+
+// FindNearDuplicates groups records whose similarity is at least the
+// threshold.  Records are grouped transitively, so every record in a group
+// is similar to at least one other in it.  Groups are returned in the order
+// of their first record.
+func FindNearDuplicates(passwords []PasswordRecord, threshold float64) []NearDuplicates {
+	parent := make([]int, len(passwords))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	scores := make(map[int]float64)
+	for i := range passwords {
+		for j := i + 1; j < len(passwords); j++ {
+			s := Similarity(&passwords[i], &passwords[j])
+			if s < threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri == rj {
+				continue
+			}
+			if ri > rj {
+				ri, rj = rj, ri
+			}
+			low := s
+			for _, r := range []int{ri, rj} {
+				if prev, ok := scores[r]; ok && prev < low {
+					low = prev
+				}
+			}
+			parent[rj] = ri
+			delete(scores, rj)
+			scores[ri] = low
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i := range passwords {
+		r := find(i)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], i)
+	}
+	sort.Ints(roots)
+
+	var groups []NearDuplicates
+	for _, r := range roots {
+		if len(members[r]) < 2 {
+			continue
+		}
+		g := NearDuplicates{Indexes: members[r], Score: scores[r]}
+		for _, i := range g.Indexes {
+			g.Records = append(g.Records, passwords[i])
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// textSimilarity is 1 minus the edit distance relative to the length of the
+// longer string.
+func textSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,55 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		typeID   byte
+		in, want string
+	}{
+		{pwsafe.URL, "HTTPS://Example.com/ ", "example.com"},
+		{pwsafe.URL, "http://example.com", "example.com"},
+		{pwsafe.Username, " Colin ", "colin"},
+		{pwsafe.Title, "Bank ", "Bank"},
+		{pwsafe.Password, "secret ", "secret "},
+	}
+	for _, tt := range tests {
+		if got := pwsafe.Normalise(tt.typeID, tt.in); got != tt.want {
+			t.Errorf("Normalise(%s, %q) = %q, want %q", pwsafe.FieldName(tt.typeID), tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFindNearDuplicates(t *testing.T) {
+	a := record(uuid.New(), "bank", "User", "pass")
+	a.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "http://bank.example.com"}
+	b := record(uuid.New(), "bank ", "user", "pass")
+	b.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example.com/"}
+	c := record(uuid.New(), "shop", "someone", "other")
+	d := record(uuid.New(), "banks", "user", "pass")
+	d.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example.com/"}
+
+	if s := pwsafe.Similarity(&a, &b); s != 1 {
+		t.Errorf("Expected a and b to be identical once normalised, got %f", s)
+	}
+
+	groups := pwsafe.FindNearDuplicates([]pwsafe.PasswordRecord{a, c, b, d}, 0.9)
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(groups))
+	}
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, b, d}, groups[0].Records); diff != "" {
+		t.Errorf("Unexpected group (-want +got):\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]int{0, 2, 3}, groups[0].Indexes); diff != "" {
+		t.Errorf("Unexpected indexes (-want +got):\n%s\n", diff)
+	}
+	if groups[0].Score >= 1 || groups[0].Score < 0.9 {
+		t.Errorf("Unexpected score %f", groups[0].Score)
+	}
+}
@@ -0,0 +1,160 @@
+// Package pinentry asks for a password using a pinentry program, speaking
+// the Assuan protocol to it.
+//
+// https://www.gnupg.org/documentation/manuals/assuan/
+package pinentry
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrCancelled is returned when the user cancels the dialog.
+var ErrCancelled = errors.New("pinentry cancelled")
+
+// the error code pinentry uses for a cancelled dialog
+const cancelled = "83886179"
+
+type Pinentry struct {
+	// the program to run, e.g. pinentry-curses
+	Path string
+	// the terminal to use when stdin isn't one, e.g. /dev/pts/1
+	TTY string
+
+	Title       string
+	Description string
+	Prompt      string
+	// shown when asking again after a bad password
+	Error string
+}
+
+// GetPIN runs the pinentry program and returns what the user entered.
+func (p *Pinentry) GetPIN() ([]byte, error) {
+	cmd := exec.Command(p.Path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	c := conn{w: stdin, r: bufio.NewReader(stdout)}
+	if _, err := c.response(); err != nil {
+		return nil, err
+	}
+
+	var commands []string
+	if p.TTY != "" {
+		commands = append(commands, "OPTION ttyname="+escape(p.TTY))
+	}
+	for _, s := range []struct{ command, value string }{
+		{"SETTITLE", p.Title},
+		{"SETDESC", p.Description},
+		{"SETPROMPT", p.Prompt},
+		{"SETERROR", p.Error},
+	} {
+		if s.value != "" {
+			commands = append(commands, s.command+" "+escape(s.value))
+		}
+	}
+	for _, command := range commands {
+		if _, err := c.call(command); err != nil {
+			return nil, err
+		}
+	}
+
+	pin, err := c.call("GETPIN")
+	if err != nil {
+		return nil, err
+	}
+	// BYE is a courtesy, we have what we need
+	c.call("BYE")
+	return pin, nil
+}
+
+type conn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *conn) call(command string) ([]byte, error) {
+	if _, err := fmt.Fprintf(c.w, "%s\n", command); err != nil {
+		return nil, err
+	}
+	return c.response()
+}
+
+// response reads lines until OK or ERR, returning any data sent.
+func (c *conn) response() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("pinentry closed the connection")
+			}
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			fields := strings.SplitN(line, " ", 3)
+			if fields[1] == cancelled {
+				return nil, ErrCancelled
+			}
+			return nil, fmt.Errorf("pinentry error: %s", strings.Join(fields[1:], " "))
+		case strings.HasPrefix(line, "D "):
+			d, err := unescape(line[2:])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, d...)
+		case strings.HasPrefix(line, "INQUIRE "):
+			// we have nothing to give it
+			if _, err := fmt.Fprintf(c.w, "CAN\n"); err != nil {
+				return nil, err
+			}
+		}
+		// status lines (S) and comments (#) are ignored
+	}
+}
+
+// escape percent encodes the characters that can't be sent in a line.
+func escape(s string) string {
+	r := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	return r.Replace(s)
+}
+
+func unescape(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return nil, fmt.Errorf("bad escape in pinentry data")
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad escape in pinentry data: %w", err)
+		}
+		out = append(out, byte(b))
+		i += 2
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package pinentry_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/colinnewell/pwsafe-de-dup/pinentry"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakePinentry writes a script that speaks enough of the protocol to answer
+// GETPIN, logging the commands it's sent.
+func fakePinentry(t *testing.T, getpin string) (string, string) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	script := `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r line; do
+	echo "$line" >> ` + log + `
+	case "$line" in
+	GETPIN)
+		` + getpin + `
+		;;
+	BYE)
+		echo "OK closing connection"
+		exit 0
+		;;
+	*)
+		echo OK
+		;;
+	esac
+done
+`
+	path := filepath.Join(dir, "pinentry")
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path, log
+}
+
+func TestGetPIN(t *testing.T) {
+	path, log := fakePinentry(t, `echo "S PASSWORD_FROM_CACHE"; echo "D sec%25ret"; echo "D %0Amore"; echo OK`)
+
+	p := pinentry.Pinentry{
+		Path:        path,
+		Description: "Enter the password for\ndb.psafe3 (100%)",
+		Prompt:      "Password:",
+	}
+	pin, err := p.GetPIN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pin) != "sec%ret\nmore" {
+		t.Errorf("Unexpected pin %q", pin)
+	}
+
+	sent, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SETDESC Enter the password for%0Adb.psafe3 (100%25)",
+		"SETPROMPT Password:",
+		"GETPIN",
+		"BYE",
+	}
+	got := strings.Split(strings.TrimSpace(string(sent)), "\n")
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("Unexpected commands sent (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestGetPINCancelled(t *testing.T) {
+	path, _ := fakePinentry(t, `echo "ERR 83886179 Operation cancelled <Pinentry>"`)
+
+	p := pinentry.Pinentry{Path: path}
+	if _, err := p.GetPIN(); !errors.Is(err, pinentry.ErrCancelled) {
+		t.Errorf("Expected ErrCancelled, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PlainTextTimeLayout is how times are written in the plain text export.
+const PlainTextTimeLayout = "2006/01/02 15:04:05"
+
+// the columns of the desktop client's plain text export after Group/Title,
+// in order.
+var plainTextColumns = []struct {
+	name   string
+	typeID byte
+}{
+	{"Username", Username},
+	{"Password", Password},
+	{"URL", URL},
+	{"AutoType", Autotype},
+	{"Created Time", CreationTime},
+	{"Password Modified Time", PasswordModificationTime},
+	{"Last Access Time", LastAccessTime},
+	{"Password Expiry Date", PasswordExpiryTime},
+	{"Password Expiry Interval", PasswordExpiryInterval},
+	{"Record Modified Time", LastModificationTime},
+	{"Password Policy", PasswordPolicy},
+	{"Password Policy Name", PasswordPolicyName},
+	{"History", PasswordHistory},
+	{"Run Command", RunCommand},
+	{"DCA", DoubleClickAction},
+	{"Shift+DCA", ShiftDoubleClickAction},
+	{"e-mail", EMailAddress},
+	{"Protected", ProtectedEntry},
+	{"Symbols", OwnSymbolsForPassword},
+	{"Keyboard Shortcut", EntryKeyboardShortcut},
+	{"Notes", Notes},
+}
+
+// WritePlainText writes the records in the tab delimited layout the desktop
+// client exports and imports.  The first column is the group and title
+// joined by a dot, with any dots in the title written as », and line breaks
+// in the notes are also written as ».  It includes the passwords.
+func WritePlainText(w io.Writer, passwords []PasswordRecord) error {
+	heading := []string{"Group/Title"}
+	for _, c := range plainTextColumns {
+		heading = append(heading, c.name)
+	}
+	if _, err := fmt.Fprintf(w, "%s\r\n", strings.Join(heading, "\t")); err != nil {
+		return err
+	}
+	for _, p := range passwords {
+		row := []string{GroupTitle(&p)}
+		for _, c := range plainTextColumns {
+			row = append(row, plainTextValue(&p, c.typeID))
+		}
+		if _, err := fmt.Fprintf(w, "%s\r\n", strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupTitle is the group and title as the desktop client writes them in
+// one column, e.g. "web.shops.example»com".
+func GroupTitle(p *PasswordRecord) string {
+	title := strings.ReplaceAll(p.Text(Title), ".", "»")
+	if g := p.Text(Group); g != "" {
+		return g + "." + title
+	}
+	return title
+}
+
+func plainTextValue(p *PasswordRecord, typeID byte) string {
+	f, ok := p.Fields[typeID]
+	if !ok {
+		return ""
+	}
+	var v string
+	switch d := f.Data.(type) {
+	case string:
+		v = d
+	case uint32:
+		v = time.Unix(int64(d), 0).Format(PlainTextTimeLayout)
+	case []byte:
+		// the binary fields are little endian numbers
+		var b [8]byte
+		copy(b[:], d)
+		v = fmt.Sprint(binary.LittleEndian.Uint64(b[:]))
+	default:
+		v = fmt.Sprint(d)
+	}
+	if typeID == Notes {
+		v = strings.NewReplacer("\r\n", "»", "\n", "»", "\r", "»").Replace(v)
+		return `"` + strings.ReplaceAll(v, "\t", " ") + `"`
+	}
+	return strings.ReplaceAll(v, "\t", " ")
+}
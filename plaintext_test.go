@@ -0,0 +1,51 @@
+package pwsafe_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestWritePlainText(t *testing.T) {
+	r := record(uuid.New(), "example.com", "user", "pass")
+	r.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "web.shops"}
+	r.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "line 1\r\nline\t2"}
+	r.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(1600000000)}
+	r.Fields[pwsafe.DoubleClickAction] = pwsafe.Field{Type: pwsafe.DoubleClickAction, Data: []byte{1, 1}}
+
+	var b bytes.Buffer
+	if err := pwsafe.WritePlainText(&b, []pwsafe.PasswordRecord{r}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(b.String(), "\r\n")
+	if len(lines) != 3 || lines[2] != "" {
+		t.Fatalf("Expected a heading and one record, got %q", b.String())
+	}
+	heading := strings.Split(lines[0], "\t")
+	row := strings.Split(lines[1], "\t")
+	if len(heading) != len(row) {
+		t.Fatalf("Expected %d columns, got %d", len(heading), len(row))
+	}
+	got := make(map[string]string)
+	for i, h := range heading {
+		if row[i] != "" {
+			got[h] = row[i]
+		}
+	}
+	expected := map[string]string{
+		"Group/Title":  "web.shops.example»com",
+		"Username":     "user",
+		"Password":     "pass",
+		"Created Time": time.Unix(1600000000, 0).Format(pwsafe.PlainTextTimeLayout),
+		"DCA":          "257",
+		"Notes":        `"line 1»line 2"`,
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("Unexpected columns (-want +got):\n%s\n", diff)
+	}
+}
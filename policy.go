@@ -0,0 +1,118 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// passwordPolicy is the parsed form of the PasswordPolicy field.
+//
+// The field is stored as "ffffnnnllluuudddsss", all numbers in hex.  ffff
+// are the flags, nnn is the length and lll, uuu, ddd and sss are the least
+// number of lowercase letters, uppercase letters, digits and symbols.
+type passwordPolicy struct {
+	flags                                             uint16
+	length, minLower, minUpper, minDigits, minSymbols int
+}
+
+// namedPolicy is one of the NamedPasswordPolicies header's policies.
+//
+// The header is stored as "NN" followed by NN policies of
+// "LLnameffffnnnllluuudddsssMMsymbols", all numbers in hex.  LL is the
+// length of the name, the policy is as it is in the field, and MM is the
+// length of the symbols it uses, 0 for the default ones.
+type namedPolicy struct {
+	name string
+	passwordPolicy
+	symbols string
+}
+
+const policyLength = 19
+
+func parsePolicy(s string) (passwordPolicy, error) {
+	if len(s) != policyLength {
+		return passwordPolicy{}, fmt.Errorf("password policy is %d characters, expected %d", len(s), policyLength)
+	}
+	flags, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return passwordPolicy{}, fmt.Errorf("password policy flags invalid: %w", err)
+	}
+	p := passwordPolicy{flags: uint16(flags)}
+	for i, v := range []*int{&p.length, &p.minLower, &p.minUpper, &p.minDigits, &p.minSymbols} {
+		n, err := strconv.ParseUint(s[4+i*3:7+i*3], 16, 12)
+		if err != nil {
+			return passwordPolicy{}, fmt.Errorf("password policy length invalid: %w", err)
+		}
+		*v = int(n)
+	}
+	return p, nil
+}
+
+func (p passwordPolicy) String() string {
+	return fmt.Sprintf("%04x%03x%03x%03x%03x%03x", p.flags, p.length, p.minLower, p.minUpper, p.minDigits, p.minSymbols)
+}
+
+func parseNamedPolicies(s string) ([]namedPolicy, error) {
+	if len(s) < 2 {
+		return nil, fmt.Errorf("named password policies too short")
+	}
+	num, err := strconv.ParseUint(s[:2], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("named password policies count invalid: %w", err)
+	}
+	rest := s[2:]
+	// the lengths are in characters, not bytes
+	text := func() (string, error) {
+		if len(rest) < 2 {
+			return "", fmt.Errorf("truncated")
+		}
+		length, err := strconv.ParseUint(rest[:2], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("length invalid: %w", err)
+		}
+		rest = rest[2:]
+		end := 0
+		for n := uint64(0); n < length; n++ {
+			if end >= len(rest) {
+				return "", fmt.Errorf("truncated")
+			}
+			_, size := utf8.DecodeRuneInString(rest[end:])
+			end += size
+		}
+		t := rest[:end]
+		rest = rest[end:]
+		return t, nil
+	}
+
+	var policies []namedPolicy
+	for i := uint64(0); i < num; i++ {
+		var p namedPolicy
+		if p.name, err = text(); err != nil {
+			return nil, fmt.Errorf("named password policy %d name %w", i, err)
+		}
+		if len(rest) < policyLength {
+			return nil, fmt.Errorf("named password policy %d truncated", i)
+		}
+		if p.passwordPolicy, err = parsePolicy(rest[:policyLength]); err != nil {
+			return nil, fmt.Errorf("named password policy %d: %w", i, err)
+		}
+		rest = rest[policyLength:]
+		if p.symbols, err = text(); err != nil {
+			return nil, fmt.Errorf("named password policy %d symbols %w", i, err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func formatNamedPolicies(policies []namedPolicy) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%02x", len(policies))
+	for _, p := range policies {
+		fmt.Fprintf(&b, "%02x%s%s%02x%s", utf8.RuneCountInString(p.name), p.name,
+			p.passwordPolicy, utf8.RuneCountInString(p.symbols), p.symbols)
+	}
+	return b.String()
+}
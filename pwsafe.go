@@ -7,12 +7,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/base32"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"os"
 	"sort"
-	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/google/uuid"
@@ -75,6 +77,21 @@ const (
 	Username                 = 0x04
 )
 
+// SecretFields are the record fields that hold secrets.
+var SecretFields = []byte{
+	CreditCardNumber,
+	CreditCardPIN,
+	CreditCardVerifValue,
+	Password,
+	PasswordHistory,
+	TwoFactorKey,
+}
+
+var ErrIncorrectPassword = errors.New("password incorrect")
+
+// ErrCorrupt is wrapped by the errors Load returns when the file is damaged.
+var ErrCorrupt = errors.New("file corrupt")
+
 type V3File struct {
 	Headers   []HeaderRecord
 	Passwords []PasswordRecord
@@ -113,18 +130,9 @@ type recordHeader struct {
 	Raw    [11]byte
 }
 
+// String prints the record with all the secrets masked.
 func (p *PasswordRecord) String() string {
-	var b strings.Builder
-	b.WriteString("== PasswordRecord ==\n")
-	keys := p.sortedFieldKey()
-	for _, k := range keys {
-		if k != Password {
-			v := p.Fields[k]
-			b.WriteString(v.String())
-			b.WriteString("\n")
-		}
-	}
-	return b.String()
+	return p.Redacted(Redaction{})
 }
 
 func NewPasswordRecord() PasswordRecord {
@@ -154,8 +162,13 @@ func (p *PasswordRecord) Sha256() [32]byte {
 }
 
 func (f *Field) String() string {
+	return fmt.Sprintf("%s: %v", FieldName(f.Type), f.Data)
+}
+
+// FieldName returns the name of a record field type.
+func FieldName(typeID byte) string {
 	var typename string
-	switch f.Type {
+	switch typeID {
 	case Autotype:
 		typename = "Autotype"
 	case CreationTime:
@@ -217,9 +230,9 @@ func (f *Field) String() string {
 	case UUID:
 		typename = "UUID"
 	default:
-		typename = fmt.Sprintf("Unknown (%d)", f.Type)
+		typename = fmt.Sprintf("Unknown (%d)", typeID)
 	}
-	return fmt.Sprintf("%s: %v", typename, f.Data)
+	return typename
 }
 
 func (p *PasswordRecord) AddField(typeID byte, rawData []byte) error {
@@ -235,6 +248,9 @@ func (p *PasswordRecord) AddField(typeID byte, rawData []byte) error {
 	case CreationTime, PasswordModificationTime, LastAccessTime,
 		PasswordExpiryTime, LastModificationTime:
 		// time_t
+		if err := checkLength(FieldName(typeID), rawData, 4); err != nil {
+			return err
+		}
 		data = binary.LittleEndian.Uint32(rawData[:])
 	case Group, Autotype, CreditCardExpiration, CreditCardNumber,
 		CreditCardPIN, CreditCardVerifValue, EMailAddress, Notes,
@@ -253,9 +269,59 @@ func (p *PasswordRecord) AddField(typeID byte, rawData []byte) error {
 	return nil
 }
 
+// Text returns the value of a string field, or "" if it isn't set.
+func (p *PasswordRecord) Text(typeID byte) string {
+	if s, ok := p.Fields[typeID].Data.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// Time returns the value of a time_t field.
+func (p *PasswordRecord) Time(typeID byte) (uint32, bool) {
+	t, ok := p.Fields[typeID].Data.(uint32)
+	return t, ok
+}
+
+// Display returns a field as text, or "" if it isn't set.  Times are in UTC
+// in the layout given.
+func (p *PasswordRecord) Display(typeID byte, layout string) string {
+	f, ok := p.Fields[typeID]
+	if !ok {
+		return ""
+	}
+	if t, ok := p.Time(typeID); ok {
+		return time.Unix(int64(t), 0).UTC().Format(layout)
+	}
+	return f.text()
+}
+
+// totpEncoding is how TOTP keys are written, the way authenticator apps
+// take them.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// text gives the value of the field, with the TOTP key in base32.
+func (f *Field) text() string {
+	if b, ok := f.Data.([]byte); ok && f.Type == TwoFactorKey {
+		return totpEncoding.EncodeToString(b)
+	}
+	return fmt.Sprint(f.Data)
+}
+
+// ID returns the UUID of the record.
+func (p *PasswordRecord) ID() (uuid.UUID, bool) {
+	u, ok := p.Fields[UUID].Data.(uuid.UUID)
+	return u, ok
+}
+
 func (h *HeaderRecord) String() string {
+	return fmt.Sprintf("%s: %v", HeaderName(h.Type), h.Data)
+}
+
+// HeaderName returns the name of a header field type.
+func HeaderName(typeID byte) string {
 	var typename string
-	switch h.Type {
+	switch typeID {
 	case DatabaseDescription:
 		typename = "DatabaseDescription"
 	case DatabaseFilters:
@@ -293,9 +359,9 @@ func (h *HeaderRecord) String() string {
 	case Yubico:
 		typename = "Yubico"
 	default:
-		typename = fmt.Sprintf("Unknown (%d)", h.Type)
+		typename = fmt.Sprintf("Unknown (%d)", typeID)
 	}
-	return fmt.Sprintf("%s: %v", typename, h.Data)
+	return typename
 }
 
 func NewHeader(typeID byte, rawData []byte) (HeaderRecord, error) {
@@ -303,6 +369,9 @@ func NewHeader(typeID byte, rawData []byte) (HeaderRecord, error) {
 	switch typeID {
 	case Version:
 		// 2 bytes, major/minor
+		if err := checkLength(HeaderName(typeID), rawData, 2); err != nil {
+			return HeaderRecord{}, err
+		}
 		data = fmt.Sprintf("%d.%d", rawData[1], rawData[0])
 	case UUID:
 		// uuid
@@ -313,6 +382,9 @@ func NewHeader(typeID byte, rawData []byte) (HeaderRecord, error) {
 		}
 	case TimestampOfLastSave, LastMasterPasswordChange:
 		// time_t
+		if err := checkLength(HeaderName(typeID), rawData, 4); err != nil {
+			return HeaderRecord{}, err
+		}
 		data = binary.LittleEndian.Uint32(rawData[:])
 	case DatabaseDescription, DatabaseFilters, DatabaseName, EmptyGroups,
 		EndOfEntry, LastSavedByUser, LastSavedOnHost, NamedPasswordPolicies,
@@ -326,13 +398,50 @@ func NewHeader(typeID byte, rawData []byte) (HeaderRecord, error) {
 	return HeaderRecord{Type: typeID, Data: data}, nil
 }
 
+func checkLength(name string, rawData []byte, n int) error {
+	if len(rawData) != n {
+		return fmt.Errorf("%s is %d bytes, expected %d", name, len(rawData), n)
+	}
+	return nil
+}
+
+// Load reads and decrypts a safe.  Fields that can't be decoded are an
+// error wrapping ErrCorrupt.
 func Load(file *os.File, password []byte) (V3File, error) {
+	return load(file, password, nil)
+}
+
+// Verify reads a safe like Load, and also returns warnings about problems
+// with its structure that don't stop it being read.  Fields that can't be
+// decoded are only a warning, and are kept as raw bytes.
+func Verify(file *os.File, password []byte) (V3File, []string, error) {
+	var warnings []string
+	v3, err := load(file, password, func(format string, a ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, a...))
+	})
+	if err != nil {
+		return V3File{}, nil, err
+	}
+	if len(v3.Headers) == 0 || v3.Headers[0].Type != Version {
+		warnings = append(warnings, "the first header isn't the Version")
+	}
+	return v3, warnings, nil
+}
+
+// load reads the safe, passing problems that don't stop it being read to
+// warn.  If warn is nil fields that can't be decoded are an error and the
+// other problems are ignored.
+func load(file *os.File, password []byte, warn func(format string, a ...interface{})) (V3File, error) {
+	strict := warn == nil
+	if strict {
+		warn = func(string, ...interface{}) {}
+	}
 	info, err := file.Stat()
 	if err != nil {
 		return V3File{}, err
 	}
 	if info.Size() < 232 {
-		return V3File{}, fmt.Errorf("file truncated")
+		return V3File{}, fmt.Errorf("%w: file truncated", ErrCorrupt)
 	}
 
 	defer file.Close()
@@ -354,11 +463,11 @@ func Load(file *os.File, password []byte) (V3File, error) {
 		return V3File{}, err
 	}
 	if string(s.Tag[:]) != "PWS3" {
-		return V3File{}, fmt.Errorf("header tag missing")
+		return V3File{}, fmt.Errorf("%w: header tag missing", ErrCorrupt)
 	}
 
 	if s.ITER < 2048 {
-		return V3File{}, fmt.Errorf("iterations too small")
+		return V3File{}, fmt.Errorf("%w: iterations too small", ErrCorrupt)
 	}
 
 	h := sha256.New()
@@ -374,11 +483,9 @@ func Load(file *os.File, password []byte) (V3File, error) {
 	h.Write(p)
 	hp := h.Sum(nil)
 	if subtle.ConstantTimeCompare(hp, s.HP[:]) == 0 {
-		return V3File{}, fmt.Errorf("password incorrect")
+		return V3File{}, ErrIncorrectPassword
 	}
 
-	fmt.Println("")
-
 	e, err := twofish.NewCipher(p)
 	if err != nil {
 		return V3File{}, err
@@ -403,12 +510,17 @@ func Load(file *os.File, password []byte) (V3File, error) {
 
 	var pwRecord *PasswordRecord
 	var passwords []PasswordRecord
+	eof := false
+	// how far into the file has been read, to check records fit in it
+	offset := int64(size)
 	for {
 		read, err := file.Read(chunk[:])
 		if read < 16 || err != nil {
 			break
 		}
+		offset += 16
 		if string(chunk[:]) == "PWS3-EOFPWS3-EOF" {
+			eof = true
 			break
 		}
 		mode.CryptBlocks(chunk[:], chunk[:])
@@ -419,9 +531,8 @@ func Load(file *os.File, password []byte) (V3File, error) {
 			return V3File{}, err
 		}
 
-		// FIXME: not sure what the ideal sanity check here is.
-		if record.Length > 1000 {
-			return V3File{}, fmt.Errorf("record length %d seems too large", record.Length)
+		if record.Length > 11 && int64(record.Length-11) > info.Size()-offset {
+			return V3File{}, fmt.Errorf("%w: record length %d is longer than the rest of the file", ErrCorrupt, record.Length)
 		}
 		rawData := make([]byte, record.Length)
 		if record.Length >= 11 {
@@ -431,8 +542,9 @@ func Load(file *os.File, password []byte) (V3File, error) {
 			for needed > 0 {
 				read, err = file.Read(chunk[:])
 				if read < 16 || err != nil {
-					break
+					return V3File{}, fmt.Errorf("%w: record truncated", ErrCorrupt)
 				}
+				offset += 16
 				mode.CryptBlocks(chunk[:], chunk[:])
 
 				if needed > 16 {
@@ -454,6 +566,9 @@ func Load(file *os.File, password []byte) (V3File, error) {
 
 		if record.Type == 0xff {
 			if pwRecord != nil {
+				if len(pwRecord.Fields) == 0 {
+					warn("record %d is empty", len(passwords)+1)
+				}
 				passwords = append(passwords, *pwRecord)
 			}
 			rec := NewPasswordRecord()
@@ -463,30 +578,41 @@ func Load(file *os.File, password []byte) (V3File, error) {
 		if pwRecord == nil {
 			h, err := NewHeader(record.Type, rawData)
 			if err != nil {
-				return V3File{}, err
+				if strict {
+					return V3File{}, fmt.Errorf("%w: header %d: %s", ErrCorrupt, len(headerList)+1, err)
+				}
+				warn("header %d: %s", len(headerList)+1, err)
+				h = HeaderRecord{Type: record.Type, Data: rawData}
 			}
 			headerList = append(headerList, h)
 		} else {
+			if _, ok := pwRecord.Fields[record.Type]; ok {
+				warn("record %d has more than one %s field", len(passwords)+1, FieldName(record.Type))
+			}
 			err := pwRecord.AddField(record.Type, rawData)
 			if err != nil {
-				return V3File{}, err
+				if strict {
+					return V3File{}, fmt.Errorf("%w: record %d: %s", ErrCorrupt, len(passwords)+1, err)
+				}
+				warn("record %d: %s", len(passwords)+1, err)
+				pwRecord.Fields[record.Type] = Field{Type: record.Type, Data: rawData}
 			}
 		}
 	}
-	if err != nil {
-		return V3File{}, err
+	if !eof {
+		return V3File{}, fmt.Errorf("%w: end of file marker missing", ErrCorrupt)
+	}
+	if pwRecord != nil && len(pwRecord.Fields) > 0 {
+		warn("%d fields after the last record", len(pwRecord.Fields))
 	}
 	var storedHMAC [32]byte
 	read, err = file.Read(storedHMAC[:])
-	if err != nil {
-		return V3File{}, err
-	}
-	if read < 32 {
-		return V3File{}, fmt.Errorf("missed hmac")
+	if err != nil || read < 32 {
+		return V3File{}, fmt.Errorf("%w: missed hmac", ErrCorrupt)
 	}
 	actualHMAC := hm.Sum(nil)
 	if !hmac.Equal(actualHMAC, storedHMAC[:]) {
-		return V3File{}, fmt.Errorf("HMAC doesn't match")
+		return V3File{}, fmt.Errorf("%w: HMAC doesn't match", ErrCorrupt)
 	}
 
 	return V3File{Headers: headerList, Passwords: passwords}, nil
@@ -629,19 +755,7 @@ func constructFieldData(typeID byte, data interface{}, hm hash.Hash) ([]byte, er
 	case EndOfEntry:
 		dataInBytes = make([]byte, 0)
 	default:
-		switch v := data.(type) {
-		case []byte:
-			dataInBytes = v
-		case string:
-			dataInBytes = []byte(v)
-		case uint32:
-			dataInBytes = make([]byte, 4)
-			binary.LittleEndian.PutUint32(dataInBytes[:], v)
-		case uuid.UUID:
-			dataInBytes, _ = v.MarshalBinary()
-		default:
-			panic(fmt.Errorf("unexpected data type %T to convert", data))
-		}
+		dataInBytes = fieldBytes(data)
 	}
 
 	hm.Write(dataInBytes)
@@ -660,3 +774,21 @@ func constructFieldData(typeID byte, data interface{}, hm hash.Hash) ([]byte, er
 	copy(blocks[5:], dataInBytes)
 	return blocks, nil
 }
+
+// fieldBytes converts the value of a field back to how it's stored.
+func fieldBytes(data interface{}) []byte {
+	switch v := data.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case uint32:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	case uuid.UUID:
+		b, _ := v.MarshalBinary()
+		return b
+	}
+	panic(fmt.Errorf("unexpected data type %T to convert", data))
+}
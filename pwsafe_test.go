@@ -1,7 +1,9 @@
 package pwsafe_test
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
 	pwsafe "github.com/colinnewell/pwsafe-de-dup"
@@ -73,3 +75,135 @@ func TestRoundTrip(t *testing.T) {
 		t.Errorf("Round trip not identical (-wrote +read):\n%s\n", diff)
 	}
 }
+
+func writeSafe(t *testing.T, pwFile pwsafe.V3File, password []byte) string {
+	t.Helper()
+	op, err := os.CreateTemp(t.TempDir(), "psafe3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pwFile.Write(op, password); err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return op.Name()
+}
+
+func TestLongRecord(t *testing.T) {
+	password := []byte("test password")
+	r := record(uuid.New(), "title", "user", "pass")
+	// merged notes and long histories go well past a few blocks
+	r.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: strings.Repeat("notes ", 2000)}
+	pwFile := pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.0"}},
+		Passwords: []pwsafe.PasswordRecord{r},
+	}
+	filename := writeSafe(t, pwFile, password)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	readFile, err := pwsafe.Load(file, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(pwFile, readFile); diff != "" {
+		t.Errorf("Round trip not identical (-wrote +read):\n%s\n", diff)
+	}
+}
+
+func verify(t *testing.T, filename string, password []byte) ([]string, error) {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, warnings, err := pwsafe.Verify(file, password)
+	return warnings, err
+}
+
+func TestVerify(t *testing.T) {
+	password := []byte("test password")
+	filename := writeSafe(t, pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.0"}},
+		Passwords: []pwsafe.PasswordRecord{record(uuid.New(), "title", "user", "pass")},
+	}, password)
+
+	warnings, err := verify(t, filename, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	if _, err := verify(t, filename, []byte("wrong")); !errors.Is(err, pwsafe.ErrIncorrectPassword) {
+		t.Errorf("Expected incorrect password, got %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last encrypted block, just before the EOF marker and the HMAC
+	data[len(data)-16-32-1] ^= 0xff
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verify(t, filename, password); !errors.Is(err, pwsafe.ErrCorrupt) {
+		t.Errorf("Expected corruption, got %v", err)
+	}
+}
+
+func TestVerifyWarnings(t *testing.T) {
+	password := []byte("test password")
+	filename := writeSafe(t, pwsafe.V3File{
+		Passwords: []pwsafe.PasswordRecord{
+			record(uuid.New(), "title", "user", "pass"),
+			pwsafe.NewPasswordRecord(),
+		},
+	}, password)
+
+	warnings, err := verify(t, filename, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"record 2 is empty", "the first header isn't the Version"}
+	if diff := cmp.Diff(expected, warnings); diff != "" {
+		t.Errorf("Unexpected warnings (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestLoadBadField(t *testing.T) {
+	password := []byte("test password")
+	r := record(uuid.New(), "title", "user", "pass")
+	// a time is 4 bytes
+	r.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: []byte{1, 2, 3}}
+	filename := writeSafe(t, pwsafe.V3File{
+		Headers:   []pwsafe.HeaderRecord{{Type: pwsafe.Version, Data: "3.0"}},
+		Passwords: []pwsafe.PasswordRecord{r},
+	}, password)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := pwsafe.Load(file, password); !errors.Is(err, pwsafe.ErrCorrupt) {
+		t.Errorf("Expected corruption, got %v", err)
+	}
+
+	warnings, err := verify(t, filename, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"record 1: CreationTime is 3 bytes, expected 4"}
+	if diff := cmp.Diff(expected, warnings); diff != "" {
+		t.Errorf("Unexpected warnings (-want +got):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,48 @@
+package pwsafe
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Masked is shown in place of a secret that hasn't been revealed.
+const Masked = "********"
+
+// Redaction says which of the SecretFields can be shown.  The zero value
+// hides them all.
+type Redaction struct {
+	Reveal []byte
+}
+
+// RevealAll shows every field.
+var RevealAll = Redaction{Reveal: SecretFields}
+
+// Hidden is true if the field should be masked.
+func (r Redaction) Hidden(typeID byte) bool {
+	return slices.Contains(SecretFields, typeID) && !slices.Contains(r.Reveal, typeID)
+}
+
+// Redact returns a copy of the record with the hidden fields masked.
+func (r Redaction) Redact(p PasswordRecord) PasswordRecord {
+	redacted := NewPasswordRecord()
+	for k, f := range p.Fields {
+		if r.Hidden(k) {
+			f.Data = Masked
+		}
+		redacted.Fields[k] = f
+	}
+	return redacted
+}
+
+// Redacted prints the record with the hidden fields masked.
+func (p *PasswordRecord) Redacted(r Redaction) string {
+	redacted := r.Redact(*p)
+	var b strings.Builder
+	b.WriteString("== PasswordRecord ==\n")
+	for _, k := range redacted.sortedFieldKey() {
+		v := redacted.Fields[k]
+		fmt.Fprintf(&b, "%s: %s\n", FieldName(k), v.text())
+	}
+	return b.String()
+}
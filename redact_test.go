@@ -0,0 +1,78 @@
+package pwsafe_test
+
+import (
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestRedact(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass")
+	r.Fields[pwsafe.TwoFactorKey] = pwsafe.Field{Type: pwsafe.TwoFactorKey, Data: []byte("totp")}
+	r.Fields[pwsafe.CreditCardPIN] = pwsafe.Field{Type: pwsafe.CreditCardPIN, Data: "1234"}
+
+	redacted := pwsafe.Redaction{Reveal: []byte{pwsafe.TwoFactorKey}}.Redact(r)
+
+	expected := pwsafe.NewPasswordRecord()
+	for k, f := range r.Fields {
+		expected.Fields[k] = f
+	}
+	expected.Fields[pwsafe.Password] = pwsafe.Field{Type: pwsafe.Password, Data: pwsafe.Masked}
+	expected.Fields[pwsafe.CreditCardPIN] = pwsafe.Field{Type: pwsafe.CreditCardPIN, Data: pwsafe.Masked}
+	if diff := cmp.Diff(expected, redacted); diff != "" {
+		t.Errorf("Unexpected record (-want +got):\n%s\n", diff)
+	}
+	if r.Text(pwsafe.Password) != "pass" {
+		t.Error("Redact changed the original record")
+	}
+
+	if diff := cmp.Diff(r, pwsafe.RevealAll.Redact(r)); diff != "" {
+		t.Errorf("Unexpected record (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestStringHidesSecrets(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass")
+	r.Fields[pwsafe.CreditCardNumber] = pwsafe.Field{Type: pwsafe.CreditCardNumber, Data: "4111111111111111"}
+	r.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "10201" + "00000001" + "0003" + "old"}
+
+	s := r.String()
+	for _, secret := range []string{"pass", "4111111111111111", "old"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("Expected %q to be masked in:\n%s", secret, s)
+		}
+	}
+	if !strings.Contains(s, "Title: title") {
+		t.Errorf("Expected the title in:\n%s", s)
+	}
+}
+
+func TestRedactedTOTP(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass")
+	r.Fields[pwsafe.TwoFactorKey] = pwsafe.Field{Type: pwsafe.TwoFactorKey, Data: []byte("12345678901234567890")}
+
+	s := r.Redacted(pwsafe.Redaction{Reveal: []byte{pwsafe.TwoFactorKey}})
+	if !strings.Contains(s, "TwoFactorKey: GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n") {
+		t.Errorf("Expected the TOTP key in base32 in:\n%s", s)
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass")
+	r.Fields[pwsafe.TwoFactorKey] = pwsafe.Field{Type: pwsafe.TwoFactorKey, Data: []byte("12345678901234567890")}
+	r.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(1600000000)}
+
+	for k, expected := range map[byte]string{
+		pwsafe.Title:        "title",
+		pwsafe.TwoFactorKey: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		pwsafe.CreationTime: "2020-09-13 12:26:40",
+		pwsafe.URL:          "",
+	} {
+		if got := r.Display(k, "2006-01-02 15:04:05"); got != expected {
+			t.Errorf("Expected %s %q, got %q", pwsafe.FieldName(k), expected, got)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package pwsafe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Report describes the changes made while de-duplicating.  It identifies
+// records by UUID, title and group only, so it never contains secrets.
+type Report struct {
+	Total          int                  `json:"total"`
+	Unique         int                  `json:"unique"`
+	UUIDChanges    []ReportUUIDChange   `json:"uuid_changes"`
+	ConflictCopies []ReportConflictCopy `json:"conflict_copies"`
+	Groups         []ReportGroup        `json:"groups"`
+}
+
+type ReportRecord struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+	Group string `json:"group"`
+}
+
+type ReportUUIDChange struct {
+	Record  ReportRecord `json:"record"`
+	NewUUID string       `json:"new_uuid,omitempty"`
+	Merged  bool         `json:"merged"`
+}
+
+type ReportConflictCopy struct {
+	Copy ReportRecord `json:"copy"`
+	// nil when no original was found, or there was more than one
+	Original  *ReportRecord `json:"original"`
+	Collapsed bool          `json:"collapsed"`
+	// the records it could have been copied from when there was more than
+	// one
+	Candidates []ReportRecord `json:"candidates,omitempty"`
+}
+
+type ReportGroup struct {
+	Records []ReportRecord `json:"records"`
+	// nil when there were conflicts and all the records were kept
+	Kept      *ReportRecord `json:"kept"`
+	Reason    string        `json:"reason"`
+	Merged    bool          `json:"merged"`
+	Conflicts []string      `json:"conflicts,omitempty"`
+}
+
+func NewReport(total int) Report {
+	return Report{
+		Total:          total,
+		UUIDChanges:    []ReportUUIDChange{},
+		ConflictCopies: []ReportConflictCopy{},
+		Groups:         []ReportGroup{},
+	}
+}
+
+func NewReportRecord(p *PasswordRecord) ReportRecord {
+	r := ReportRecord{Title: p.Text(Title), Group: p.Text(Group)}
+	if u, ok := p.ID(); ok {
+		r.UUID = u.String()
+	}
+	return r
+}
+
+func (r *Report) AddUUIDChanges(changes []UUIDChange) {
+	for _, c := range changes {
+		change := ReportUUIDChange{
+			Record: ReportRecord{UUID: c.UUID.String(), Title: c.Title, Group: c.Group},
+			Merged: c.Merged,
+		}
+		if !c.Merged {
+			change.NewUUID = c.NewUUID.String()
+		}
+		r.UUIDChanges = append(r.UUIDChanges, change)
+	}
+}
+
+func (r *Report) AddConflictCopies(result ConflictCopyResult) {
+	add := func(copies []ConflictCopy, collapsed bool) {
+		for _, c := range copies {
+			original := NewReportRecord(&c.Original)
+			r.ConflictCopies = append(r.ConflictCopies, ReportConflictCopy{
+				Copy:      NewReportRecord(&c.Copy),
+				Original:  &original,
+				Collapsed: collapsed,
+			})
+		}
+	}
+	add(result.Collapsed, true)
+	add(result.Differing, false)
+	for _, p := range result.Unmatched {
+		r.ConflictCopies = append(r.ConflictCopies, ReportConflictCopy{Copy: NewReportRecord(&p)})
+	}
+	for _, a := range result.Ambiguous {
+		c := ReportConflictCopy{Copy: NewReportRecord(&a.Copy)}
+		for _, p := range a.Candidates {
+			c.Candidates = append(c.Candidates, NewReportRecord(&p))
+		}
+		r.ConflictCopies = append(r.ConflictCopies, c)
+	}
+}
+
+func (r *Report) AddDedup(result DedupResult, opts DedupOptions) {
+	r.Unique = len(result.Passwords)
+	for _, g := range result.Groups {
+		group := ReportGroup{Merged: g.Merged}
+		for _, p := range g.Records {
+			group.Records = append(group.Records, NewReportRecord(&p))
+		}
+		if len(g.Conflicts) > 0 {
+			for _, c := range g.Conflicts {
+				group.Conflicts = append(group.Conflicts, FieldName(c))
+			}
+			group.Reason = fmt.Sprintf("%s differs, kept all for review", strings.Join(group.Conflicts, " and "))
+		} else {
+			kept := group.Records[g.Kept]
+			group.Kept = &kept
+			group.Reason = g.Reason
+			if group.Reason == "" {
+				o := opts
+				if !g.decidedBy(o.Keep) {
+					// nothing to choose between them so it fell back to
+					// the order
+					o.Keep = KeepFirst
+				}
+				group.Reason = keepReason(o, g.Merged)
+			}
+		}
+		r.Groups = append(r.Groups, group)
+	}
+}
+
+func keepReason(opts DedupOptions, merged bool) string {
+	var reason string
+	switch opts.Keep {
+	case KeepOldest:
+		reason = "oldest creation time"
+	case KeepNewest:
+		reason = "newest modification time"
+	case KeepLastUsed:
+		reason = "most recently used"
+	case KeepMostFields:
+		reason = "most fields"
+	default:
+		reason = "first in file"
+	}
+	if opts.Match == MatchEntry {
+		reason += " of records with the same group, title and URL"
+	} else {
+		reason += " of records that only differ in their UUIDs and times"
+	}
+	if merged {
+		reason += ", others merged into it"
+	}
+	return reason
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
@@ -0,0 +1,57 @@
+package pwsafe_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestReport(t *testing.T) {
+	a := record(uuid.New(), "bank", "user", "hunter2")
+	a.Fields[pwsafe.CreditCardNumber] = pwsafe.Field{Type: pwsafe.CreditCardNumber, Data: "4111111111111111"}
+	old := record(uuid.New(), "bank", "user", "hunter2")
+	old.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(100)}
+	b := record(uuid.New(), "shop", "user", "hunter2")
+	c := record(uuid.New(), "shop", "user", "hunter2")
+	passwords := []pwsafe.PasswordRecord{a, b, old, c}
+
+	opts := pwsafe.DedupOptions{Keep: pwsafe.KeepOldest, Match: pwsafe.MatchEntry}
+	report := pwsafe.NewReport(len(passwords))
+	report.AddDedup(pwsafe.Dedup(passwords, opts), opts)
+
+	kept := pwsafe.NewReportRecord(&old)
+	first := pwsafe.NewReportRecord(&b)
+	expected := []pwsafe.ReportGroup{
+		{
+			Records: []pwsafe.ReportRecord{pwsafe.NewReportRecord(&a), kept},
+			Kept:    &kept,
+			Reason:  "oldest creation time of records with the same group, title and URL",
+		},
+		{
+			// neither has a creation time
+			Records: []pwsafe.ReportRecord{first, pwsafe.NewReportRecord(&c)},
+			Kept:    &first,
+			Reason:  "first in file of records with the same group, title and URL",
+		},
+	}
+	if diff := cmp.Diff(expected, report.Groups); diff != "" {
+		t.Errorf("Unexpected groups (-want +got):\n%s\n", diff)
+	}
+	if report.Total != 4 || report.Unique != 2 {
+		t.Errorf("Expected 4 total 2 unique, got %d %d", report.Total, report.Unique)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "4111111111111111"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Report contains secret %q", secret)
+		}
+	}
+}
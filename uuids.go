@@ -0,0 +1,195 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// UUIDResolution decides what happens to records that share a UUID but have
+// different contents.
+type UUIDResolution int
+
+const (
+	// ResolveNewUUID gives the later records a fresh UUID.
+	ResolveNewUUID UUIDResolution = iota
+	// ResolveMerge merges the later records into the first.  If they
+	// conflict, or look like a different entry because the title, group or
+	// URL differ, they're given a fresh UUID instead.
+	ResolveMerge
+)
+
+var uuidResolutionNames = []string{
+	ResolveNewUUID: "new-uuid",
+	ResolveMerge:   "merge",
+}
+
+func (r UUIDResolution) String() string {
+	if r < 0 || int(r) >= len(uuidResolutionNames) {
+		return fmt.Sprintf("UUIDResolution(%d)", int(r))
+	}
+	return uuidResolutionNames[r]
+}
+
+// UUIDResolutionNames lists the names accepted by ParseUUIDResolution.
+func UUIDResolutionNames() []string {
+	return append([]string(nil), uuidResolutionNames...)
+}
+
+func ParseUUIDResolution(name string) (UUIDResolution, error) {
+	for i, n := range uuidResolutionNames {
+		if n == name {
+			return UUIDResolution(i), nil
+		}
+	}
+	return ResolveNewUUID, fmt.Errorf("unknown UUID resolution %q, expected one of %s",
+		name, strings.Join(uuidResolutionNames, ", "))
+}
+
+// UUIDConflict is a set of records that share a UUID but aren't identical.
+type UUIDConflict struct {
+	UUID    uuid.UUID
+	Records []PasswordRecord
+}
+
+// UUIDChange records what was done to resolve a UUID conflict.
+type UUIDChange struct {
+	UUID  uuid.UUID
+	Title string
+	Group string
+	// the UUID the record was given, uuid.Nil if it was merged
+	NewUUID uuid.UUID
+	Merged  bool
+	// the record as it was before the change
+	Record PasswordRecord
+}
+
+func (c UUIDChange) String() string {
+	if c.Merged {
+		return fmt.Sprintf("merged %q into %s", c.Title, c.UUID)
+	}
+	return fmt.Sprintf("gave %q new UUID %s, was %s", c.Title, c.NewUUID, c.UUID)
+}
+
+// FindUUIDConflicts returns the records that share a UUID but differ in
+// content.  Records that are identical are left for Dedup.
+func FindUUIDConflicts(passwords []PasswordRecord) []UUIDConflict {
+	var order []uuid.UUID
+	byUUID := make(map[uuid.UUID][]PasswordRecord)
+	for _, p := range passwords {
+		u, ok := p.ID()
+		if !ok {
+			continue
+		}
+		if _, seen := byUUID[u]; !seen {
+			order = append(order, u)
+		}
+		byUUID[u] = append(byUUID[u], p)
+	}
+
+	var conflicts []UUIDConflict
+	for _, u := range order {
+		records := byUUID[u]
+		first := records[0].Sha256()
+		for _, r := range records[1:] {
+			if r.Sha256() != first {
+				conflicts = append(conflicts, UUIDConflict{UUID: u, Records: records})
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// ResolveUUIDConflicts returns the passwords with the conflicts found by
+// FindUUIDConflicts resolved, and a list of the changes made.  The first
+// record with a UUID keeps it.  Copies identical to a record that's changed
+// are changed the same way so Dedup can still collapse them.
+func ResolveUUIDConflicts(passwords []PasswordRecord, resolution UUIDResolution) ([]PasswordRecord, []UUIDChange) {
+	conflicting := make(map[uuid.UUID]bool)
+	for _, c := range FindUUIDConflicts(passwords) {
+		conflicting[c.UUID] = true
+	}
+	if len(conflicting) == 0 {
+		return passwords, nil
+	}
+
+	// the first version of each UUID, and the position in the output of
+	// the record that's being merged into
+	firsts := make(map[uuid.UUID][32]byte)
+	position := make(map[uuid.UUID]int)
+	// what each later version became
+	renamed := make(map[[32]byte]uuid.UUID)
+	merged := make(map[[32]byte]bool)
+	// copies of the records that were merged, to be replaced by the result
+	copies := make(map[uuid.UUID][]int)
+
+	var result []PasswordRecord
+	var changes []UUIDChange
+	for _, p := range passwords {
+		u, ok := p.ID()
+		if !ok || !conflicting[u] {
+			result = append(result, p)
+			continue
+		}
+		sha := p.Sha256()
+		first, seen := firsts[u]
+		if !seen {
+			firsts[u] = sha
+			position[u] = len(result)
+			result = append(result, p)
+			continue
+		}
+		if sha == first || merged[sha] {
+			copies[u] = append(copies[u], len(result))
+			result = append(result, p)
+			continue
+		}
+		if n, ok := renamed[sha]; ok {
+			result = append(result, withUUID(p, n))
+			continue
+		}
+
+		if resolution == ResolveMerge {
+			m, conflicts := MergeRecords([]PasswordRecord{result[position[u]], p}, 0, DefaultNotesSeparator)
+			if len(conflicts) == 0 && sameEntry(&result[position[u]], &p) {
+				result[position[u]] = m
+				merged[sha] = true
+				changes = append(changes, UUIDChange{UUID: u, Title: p.Text(Title), Group: p.Text(Group), Merged: true, Record: p})
+				continue
+			}
+		}
+		n := uuid.New()
+		renamed[sha] = n
+		result = append(result, withUUID(p, n))
+		changes = append(changes, UUIDChange{UUID: u, Title: p.Text(Title), Group: p.Text(Group), NewUUID: n, Record: p})
+	}
+	for u, indexes := range copies {
+		for _, i := range indexes {
+			result[i] = result[position[u]]
+		}
+	}
+	return result, changes
+}
+
+// sameEntry is false when the records have a different title, group or URL,
+// so they're likely to be unrelated records that got the same UUID.
+func sameEntry(a, b *PasswordRecord) bool {
+	for _, k := range []byte{Title, Group, URL} {
+		x, y := a.Text(k), b.Text(k)
+		if x != "" && y != "" && x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func withUUID(p PasswordRecord, u uuid.UUID) PasswordRecord {
+	r := NewPasswordRecord()
+	for k, v := range p.Fields {
+		r.Fields[k] = v
+	}
+	r.Fields[UUID] = Field{Type: UUID, Data: u}
+	return r
+}
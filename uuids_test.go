@@ -0,0 +1,77 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestResolveUUIDConflictsNewUUID(t *testing.T) {
+	u := uuid.New()
+	a := record(u, "a", "user", "pass")
+	b := record(u, "b", "user", "pass")
+	other := record(uuid.New(), "other", "user", "pass")
+	passwords := []pwsafe.PasswordRecord{a, b, other, a, b}
+
+	conflicts := pwsafe.FindUUIDConflicts(passwords)
+	if len(conflicts) != 1 || conflicts[0].UUID != u {
+		t.Fatalf("Expected one conflict on %s, got %+v", u, conflicts)
+	}
+
+	resolved, changes := pwsafe.ResolveUUIDConflicts(passwords, pwsafe.ResolveNewUUID)
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change, got %d", len(changes))
+	}
+	c := changes[0]
+	if c.UUID != u || c.Title != "b" || c.Merged || c.NewUUID == u || c.NewUUID == uuid.Nil {
+		t.Errorf("Unexpected change %+v", c)
+	}
+	renamed := record(c.NewUUID, "b", "user", "pass")
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, renamed, other, a, renamed}, resolved); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+	if len(pwsafe.FindUUIDConflicts(resolved)) != 0 {
+		t.Error("Expected no conflicts after resolving")
+	}
+}
+
+func TestResolveUUIDConflictsMerge(t *testing.T) {
+	u := uuid.New()
+	a := record(u, "a", "user", "pass")
+	b := record(u, "a", "user", "pass")
+	b.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "notes"}
+	c := record(u, "a", "someone", "pass")
+	passwords := []pwsafe.PasswordRecord{a, b, c, a}
+
+	resolved, changes := pwsafe.ResolveUUIDConflicts(passwords, pwsafe.ResolveMerge)
+	if len(changes) != 2 || !changes[0].Merged || changes[1].Merged {
+		t.Fatalf("Expected a merge then a new UUID, got %+v", changes)
+	}
+	merged := record(u, "a", "user", "pass")
+	merged.Fields[pwsafe.Notes] = b.Fields[pwsafe.Notes]
+	renamed := record(changes[1].NewUUID, "a", "someone", "pass")
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{merged, renamed, merged}, resolved); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+}
+
+func TestResolveUUIDConflictsMergeDifferentSites(t *testing.T) {
+	u := uuid.New()
+	// unrelated entries with no credentials that got the same UUID
+	a := record(u, "bank", "", "")
+	a.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://bank.example.com"}
+	b := record(u, "shop", "", "")
+	b.Fields[pwsafe.URL] = pwsafe.Field{Type: pwsafe.URL, Data: "https://shop.example.com"}
+
+	resolved, changes := pwsafe.ResolveUUIDConflicts([]pwsafe.PasswordRecord{a, b}, pwsafe.ResolveMerge)
+	if len(changes) != 1 || changes[0].Merged {
+		t.Fatalf("Expected a new UUID, got %+v", changes)
+	}
+	renamed := record(changes[0].NewUUID, "shop", "", "")
+	renamed.Fields[pwsafe.URL] = b.Fields[pwsafe.URL]
+	if diff := cmp.Diff([]pwsafe.PasswordRecord{a, renamed}, resolved); diff != "" {
+		t.Errorf("Unexpected passwords (-want +got):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,134 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// the earliest timestamp that looks real, Password Safe didn't exist before
+// this.
+var earliestTime = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Problem is something inconsistent in the contents of a safe.
+type Problem struct {
+	// the index of the record, or -1 for the headers
+	Record  int    `json:"record"`
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Record < 0 {
+		return "headers: " + p.Message
+	}
+	return fmt.Sprintf("record %d %q: %s", p.Record+1, p.Title, p.Message)
+}
+
+// Validate checks the records and headers make sense.  It doesn't change
+// anything.
+func (v3 *V3File) Validate() []Problem {
+	now := time.Now()
+	var problems []Problem
+
+	for _, h := range v3.Headers {
+		add := func(format string, a ...interface{}) {
+			problems = append(problems, Problem{Record: -1, Message: fmt.Sprintf(format, a...)})
+		}
+		name := HeaderName(h.Type)
+		switch v := h.Data.(type) {
+		case string:
+			if !utf8.ValidString(v) {
+				add("%s isn't valid UTF-8", name)
+			}
+		case uint32:
+			if msg := checkTime(v, now, true); msg != "" {
+				add("%s %s", name, msg)
+			}
+		case []byte:
+			if _, err := NewHeader(h.Type, v); err != nil {
+				add("%s can't be decoded, kept as raw bytes: %s", name, err)
+			}
+		}
+	}
+
+	uuids := make(map[uuid.UUID]int)
+	groups := make(map[string]bool)
+	for i, p := range v3.Passwords {
+		add := func(format string, a ...interface{}) {
+			problems = append(problems, Problem{Record: i, Title: p.Text(Title), Message: fmt.Sprintf(format, a...)})
+		}
+		if id, ok := p.ID(); !ok {
+			add("no UUID")
+		} else if first, ok := uuids[id]; ok {
+			add("UUID %s is also used by record %d", id, first+1)
+		} else {
+			uuids[id] = i
+		}
+		if p.Text(Title) == "" {
+			add("no Title")
+		}
+		if p.Text(Password) == "" {
+			add("no Password")
+		}
+		for _, k := range p.sortedFieldKey() {
+			name := FieldName(k)
+			if !knownField(k) {
+				add("unknown field type %d", k)
+				continue
+			}
+			switch v := p.Fields[k].Data.(type) {
+			case string:
+				if !utf8.ValidString(v) {
+					add("%s isn't valid UTF-8", name)
+				}
+			case uint32:
+				// expiry times are meant to be in the future
+				if msg := checkTime(v, now, k != PasswordExpiryTime); msg != "" {
+					add("%s %s", name, msg)
+				}
+			case []byte:
+				decoded := NewPasswordRecord()
+				if err := decoded.AddField(k, v); err != nil {
+					add("%s can't be decoded, kept as raw bytes: %s", name, err)
+				}
+			}
+		}
+		if g := p.Text(Group); g != "" {
+			groups[g] = true
+		}
+	}
+
+	for _, h := range v3.Headers {
+		empty, ok := h.Data.(string)
+		if h.Type != EmptyGroups || !ok {
+			continue
+		}
+		for g := range groups {
+			if g == empty || strings.HasPrefix(g, empty+".") {
+				problems = append(problems, Problem{Record: -1, Message: fmt.Sprintf("empty group %q has entries", empty)})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func checkTime(t uint32, now time.Time, past bool) string {
+	tm := time.Unix(int64(t), 0)
+	switch {
+	case tm.Before(earliestTime):
+		return fmt.Sprintf("is before 1990 (%s)", tm.UTC().Format(time.DateTime))
+	case past && tm.After(now):
+		return fmt.Sprintf("is in the future (%s)", tm.UTC().Format(time.DateTime))
+	}
+	return ""
+}
+
+// knownField is true for the record field types in the V3 spec.
+func knownField(typeID byte) bool {
+	return !strings.HasPrefix(FieldName(typeID), "Unknown")
+}
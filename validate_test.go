@@ -0,0 +1,57 @@
+package pwsafe_test
+
+import (
+	"testing"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestValidate(t *testing.T) {
+	id := uuid.New()
+	good := record(uuid.New(), "good", "user", "pass")
+	good.Fields[pwsafe.Group] = pwsafe.Field{Type: pwsafe.Group, Data: "web.shops"}
+	good.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(1600000000)}
+	// expiry is allowed to be in the future
+	good.Fields[pwsafe.PasswordExpiryTime] = pwsafe.Field{Type: pwsafe.PasswordExpiryTime, Data: uint32(4102444800)}
+
+	first := record(id, "first", "user", "pass")
+	second := record(id, "", "user", "")
+	delete(second.Fields, pwsafe.Password)
+	second.Fields[pwsafe.Notes] = pwsafe.Field{Type: pwsafe.Notes, Data: "bad \xff"}
+	noUUID := record(uuid.New(), "no uuid", "user", "pass")
+	delete(noUUID.Fields, pwsafe.UUID)
+	noUUID.Fields[pwsafe.CreationTime] = pwsafe.Field{Type: pwsafe.CreationTime, Data: uint32(100)}
+	noUUID.Fields[pwsafe.LastModificationTime] = pwsafe.Field{Type: pwsafe.LastModificationTime, Data: uint32(4102444800)}
+	noUUID.Fields[0x40] = pwsafe.Field{Type: 0x40, Data: []byte{1}}
+	// as Verify leaves fields that can't be decoded
+	noUUID.Fields[pwsafe.PasswordExpiryTime] = pwsafe.Field{Type: pwsafe.PasswordExpiryTime, Data: []byte{1, 2}}
+	noUUID.Fields[pwsafe.TwoFactorKey] = pwsafe.Field{Type: pwsafe.TwoFactorKey, Data: []byte{1, 2}}
+
+	v3 := pwsafe.V3File{
+		Headers: []pwsafe.HeaderRecord{
+			{Type: pwsafe.EmptyGroups, Data: "web"},
+			{Type: pwsafe.EmptyGroups, Data: "mail"},
+			{Type: pwsafe.TimestampOfLastSave, Data: []byte{1}},
+		},
+		Passwords: []pwsafe.PasswordRecord{good, first, second, noUUID},
+	}
+
+	expected := []pwsafe.Problem{
+		{Record: -1, Message: "TimestampOfLastSave can't be decoded, kept as raw bytes: TimestampOfLastSave is 1 bytes, expected 4"},
+		{Record: 2, Message: "UUID " + id.String() + " is also used by record 2"},
+		{Record: 2, Message: "no Title"},
+		{Record: 2, Message: "no Password"},
+		{Record: 2, Message: "Notes isn't valid UTF-8"},
+		{Record: 3, Title: "no uuid", Message: "no UUID"},
+		{Record: 3, Title: "no uuid", Message: "CreationTime is before 1990 (1970-01-01 00:01:40)"},
+		{Record: 3, Title: "no uuid", Message: "PasswordExpiryTime can't be decoded, kept as raw bytes: PasswordExpiryTime is 2 bytes, expected 4"},
+		{Record: 3, Title: "no uuid", Message: "LastModificationTime is in the future (2100-01-01 00:00:00)"},
+		{Record: 3, Title: "no uuid", Message: "unknown field type 64"},
+		{Record: -1, Message: `empty group "web" has entries`},
+	}
+	if diff := cmp.Diff(expected, v3.Validate()); diff != "" {
+		t.Errorf("Unexpected problems (-want +got):\n%s\n", diff)
+	}
+}
@@ -0,0 +1,661 @@
+package pwsafe
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// XMLTimeLayout is how times are written in XML, always in UTC.  Times
+// without a zone are read as local time, which is how the desktop client
+// writes them.
+const XMLTimeLayout = "2006-01-02T15:04:05Z"
+
+// xmlSafe is the layout of the client's pwsafe.xsd.  The headers it has no
+// place for, and fields that can't be written the way it wants them, go in
+// the unknown fields as base64, as the client does with types it doesn't
+// know.
+type xmlSafe struct {
+	XMLName        xml.Name          `xml:"passwordsafe"`
+	Delimiter      string            `xml:"delimiter,attr"`
+	XSI            string            `xml:"xmlns:xsi,attr,omitempty"`
+	Schema         string            `xml:"xsi:noNamespaceSchemaLocation,attr,omitempty"`
+	ExportTime     string            `xml:"ExportTimeStamp,attr,omitempty"`
+	Format         string            `xml:"FromDatabaseFormat,attr,omitempty"`
+	WhatSaved      string            `xml:"WhatSaved,attr,omitempty"`
+	WhenLastSaved  string            `xml:"WhenLastSaved,attr,omitempty"`
+	DatabaseUUID   string            `xml:"Database_uuid,attr,omitempty"`
+	UnknownHeaders *xmlUnknownFields `xml:"unknownheaderfields"`
+	Policies       *xmlPolicies      `xml:"NamedPasswordPolicies"`
+	EmptyGroups    *xmlEmptyGroups   `xml:"EmptyGroups"`
+	Entries        []xmlEntry        `xml:"entry"`
+}
+
+// xmlEntry has the fields in the order the schema wants them.
+type xmlEntry struct {
+	Group                    *string           `xml:"group"`
+	Title                    *string           `xml:"title"`
+	Username                 *string           `xml:"username"`
+	Password                 *string           `xml:"password"`
+	URL                      *string           `xml:"url"`
+	Autotype                 *string           `xml:"autotype"`
+	Notes                    *string           `xml:"notes"`
+	UUID                     string            `xml:"uuid,omitempty"`
+	CreationTime             string            `xml:"ctimex,omitempty"`
+	LastAccessTime           string            `xml:"atimex,omitempty"`
+	PasswordExpiryTime       string            `xml:"xtimex,omitempty"`
+	PasswordModificationTime string            `xml:"pmtimex,omitempty"`
+	LastModificationTime     string            `xml:"rmtimex,omitempty"`
+	PasswordExpiryInterval   string            `xml:"xtime_interval,omitempty"`
+	History                  *xmlHistory       `xml:"pwhistory"`
+	Policy                   *xmlPolicy        `xml:"PasswordPolicy"`
+	PolicyName               *string           `xml:"PasswordPolicyName"`
+	Symbols                  *string           `xml:"symbols"`
+	RunCommand               *string           `xml:"runcommand"`
+	DoubleClickAction        string            `xml:"dca,omitempty"`
+	ShiftDoubleClickAction   string            `xml:"shiftdca,omitempty"`
+	EMailAddress             *string           `xml:"email"`
+	Protected                string            `xml:"protected,omitempty"`
+	Unknown                  *xmlUnknownFields `xml:"unknownrecordfields"`
+}
+
+func (e *xmlEntry) texts() map[byte]**string {
+	return map[byte]**string{
+		Group:                 &e.Group,
+		Title:                 &e.Title,
+		Username:              &e.Username,
+		Password:              &e.Password,
+		URL:                   &e.URL,
+		Autotype:              &e.Autotype,
+		Notes:                 &e.Notes,
+		PasswordPolicyName:    &e.PolicyName,
+		OwnSymbolsForPassword: &e.Symbols,
+		RunCommand:            &e.RunCommand,
+		EMailAddress:          &e.EMailAddress,
+	}
+}
+
+func (e *xmlEntry) times() map[byte]*string {
+	return map[byte]*string{
+		CreationTime:             &e.CreationTime,
+		LastAccessTime:           &e.LastAccessTime,
+		PasswordExpiryTime:       &e.PasswordExpiryTime,
+		PasswordModificationTime: &e.PasswordModificationTime,
+		LastModificationTime:     &e.LastModificationTime,
+	}
+}
+
+// numbers are the fields stored as little endian integers of the given
+// size.
+func (e *xmlEntry) numbers() map[byte]xmlNumber {
+	return map[byte]xmlNumber{
+		PasswordExpiryInterval: {&e.PasswordExpiryInterval, 4},
+		DoubleClickAction:      {&e.DoubleClickAction, 2},
+		ShiftDoubleClickAction: {&e.ShiftDoubleClickAction, 2},
+		ProtectedEntry:         {&e.Protected, 1},
+	}
+}
+
+type xmlNumber struct {
+	value *string
+	size  int
+}
+
+// the lists are only written when they have something in them
+type xmlUnknownFields struct {
+	Fields []xmlUnknown `xml:"field"`
+}
+
+type xmlPolicies struct {
+	Policies []xmlNamedPolicy `xml:"Policy"`
+}
+
+type xmlEmptyGroups struct {
+	Names []string `xml:"EGName"`
+}
+
+type xmlUnknown struct {
+	Type  int    `xml:"ftype,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlHistory struct {
+	Status  int               `xml:"status"`
+	Max     int               `xml:"max"`
+	Num     int               `xml:"num"`
+	Entries []xmlHistoryEntry `xml:"history_entries>history_entry"`
+}
+
+type xmlHistoryEntry struct {
+	Num         int    `xml:"num,attr"`
+	Changed     string `xml:"changedx"`
+	OldPassword string `xml:"oldpassword"`
+}
+
+type xmlPolicyFlags struct {
+	UseDigits         int `xml:"PWUseDigits,omitempty"`
+	UseEasyVision     int `xml:"PWUseEasyVision,omitempty"`
+	UseHexDigits      int `xml:"PWUseHexDigits,omitempty"`
+	UseLowercase      int `xml:"PWUseLowercase,omitempty"`
+	UseSymbols        int `xml:"PWUseSymbols,omitempty"`
+	UseUppercase      int `xml:"PWUseUppercase,omitempty"`
+	MakePronounceable int `xml:"PWMakePronounceable,omitempty"`
+}
+
+type xmlPolicy struct {
+	Length int `xml:"PWLength"`
+	xmlPolicyFlags
+	Lowercase int `xml:"PWLowercaseMinLength"`
+	Uppercase int `xml:"PWUppercaseMinLength"`
+	Digits    int `xml:"PWDigitMinLength"`
+	Symbols   int `xml:"PWSymbolMinLength"`
+}
+
+type xmlNamedPolicy struct {
+	Name string `xml:"PWName"`
+	xmlPolicyFlags
+	Length     int    `xml:"PWDefaultLength"`
+	Digits     int    `xml:"PWDefaultDigitCount"`
+	Lowercase  int    `xml:"PWDefaultLowerCaseCount"`
+	Symbols    int    `xml:"PWDefaultSymbolCount"`
+	Uppercase  int    `xml:"PWDefaultUpperCaseCount"`
+	OwnSymbols string `xml:"symbols,omitempty"`
+}
+
+// WriteXML writes the safe in the layout of the desktop client's XML
+// schema, including the passwords.  Everything in the safe is kept, so
+// ReadXML gives back the same safe.
+func WriteXML(w io.Writer, v3 *V3File) error {
+	safe := xmlSafe{
+		// the schema needs it, the client uses it for newlines in text
+		// exports
+		Delimiter:  "»",
+		XSI:        "http://www.w3.org/2001/XMLSchema-instance",
+		Schema:     "pwsafe.xsd",
+		ExportTime: time.Now().UTC().Format(XMLTimeLayout),
+	}
+	for _, h := range v3.Headers {
+		if !safe.setHeader(h) {
+			safe.UnknownHeaders = safe.UnknownHeaders.add(h.Type, h.Data)
+		}
+	}
+	for _, p := range v3.Passwords {
+		var e xmlEntry
+		for _, k := range p.sortedFieldKey() {
+			if !e.setField(k, p.Fields[k].Data) {
+				e.Unknown = e.Unknown.add(k, p.Fields[k].Data)
+			}
+		}
+		safe.Entries = append(safe.Entries, e)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(safe); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// setHeader puts a header where the schema has a place for it, and is false
+// if it doesn't or the value can't go there unchanged.
+func (x *xmlSafe) setHeader(h HeaderRecord) bool {
+	switch d := h.Data.(type) {
+	case string:
+		switch {
+		case !xmlText(d):
+			return false
+		case h.Type == Version && x.Format == "":
+			x.Format = d
+		case h.Type == WhatPerformedLastSave && x.WhatSaved == "" && d != "":
+			x.WhatSaved = d
+		case h.Type == EmptyGroups:
+			if x.EmptyGroups == nil {
+				x.EmptyGroups = &xmlEmptyGroups{}
+			}
+			x.EmptyGroups.Names = append(x.EmptyGroups.Names, d)
+		case h.Type == NamedPasswordPolicies && x.Policies == nil:
+			policies, err := parseNamedPolicies(d)
+			if err != nil || len(policies) == 0 || formatNamedPolicies(policies) != d {
+				return false
+			}
+			var named []xmlNamedPolicy
+			for _, p := range policies {
+				if !xmlText(p.name) || !xmlText(p.symbols) {
+					return false
+				}
+				named = append(named, newXMLNamedPolicy(p))
+			}
+			x.Policies = &xmlPolicies{Policies: named}
+		default:
+			return false
+		}
+	case uuid.UUID:
+		if h.Type != UUID || x.DatabaseUUID != "" {
+			return false
+		}
+		x.DatabaseUUID = d.String()
+	case uint32:
+		if h.Type != TimestampOfLastSave || x.WhenLastSaved != "" {
+			return false
+		}
+		x.WhenLastSaved = xmlTime(d)
+	default:
+		return false
+	}
+	return true
+}
+
+// setField puts a field in its element, and is false if it has none or the
+// value can't go there unchanged.
+func (e *xmlEntry) setField(typeID byte, data interface{}) bool {
+	if s, ok := e.texts()[typeID]; ok {
+		d, ok := data.(string)
+		if !ok || !xmlText(d) {
+			return false
+		}
+		*s = &d
+		return true
+	}
+	if s, ok := e.times()[typeID]; ok {
+		d, ok := data.(uint32)
+		if !ok {
+			return false
+		}
+		*s = xmlTime(d)
+		return true
+	}
+	if n, ok := e.numbers()[typeID]; ok {
+		d, ok := data.([]byte)
+		if !ok || len(d) != n.size {
+			return false
+		}
+		switch n.size {
+		case 1:
+			// protected is 0 or 1
+			if d[0] > 1 {
+				return false
+			}
+			*n.value = strconv.Itoa(int(d[0]))
+		case 2:
+			*n.value = strconv.Itoa(int(binary.LittleEndian.Uint16(d)))
+		default:
+			*n.value = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(d)), 10)
+		}
+		return true
+	}
+	switch typeID {
+	case UUID:
+		d, ok := data.(uuid.UUID)
+		if !ok {
+			return false
+		}
+		e.UUID = hex.EncodeToString(d[:])
+	case PasswordHistory:
+		d, ok := data.(string)
+		if !ok {
+			return false
+		}
+		h, err := ParseHistory(d)
+		if err != nil || h.String() != d {
+			return false
+		}
+		for _, he := range h.Entries {
+			if !xmlText(he.Password) {
+				return false
+			}
+		}
+		e.History = newXMLHistory(h)
+	case PasswordPolicy:
+		d, ok := data.(string)
+		if !ok {
+			return false
+		}
+		p, err := parsePolicy(d)
+		if err != nil || p.String() != d {
+			return false
+		}
+		e.Policy = &xmlPolicy{
+			Length:         p.length,
+			xmlPolicyFlags: newXMLPolicyFlags(p.flags),
+			Lowercase:      p.minLower,
+			Uppercase:      p.minUpper,
+			Digits:         p.minDigits,
+			Symbols:        p.minSymbols,
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (x *xmlUnknownFields) add(typeID byte, data interface{}) *xmlUnknownFields {
+	if x == nil {
+		x = &xmlUnknownFields{}
+	}
+	x.Fields = append(x.Fields, xmlUnknown{Type: int(typeID), Value: base64.StdEncoding.EncodeToString(fieldBytes(data))})
+	return x
+}
+
+func (x *xmlUnknownFields) list() []xmlUnknown {
+	if x == nil {
+		return nil
+	}
+	return x.Fields
+}
+
+func newXMLHistory(h History) *xmlHistory {
+	x := &xmlHistory{Max: h.Max, Num: len(h.Entries)}
+	if h.Enabled {
+		x.Status = 1
+	}
+	for i, e := range h.Entries {
+		x.Entries = append(x.Entries, xmlHistoryEntry{
+			Num:         i + 1,
+			Changed:     xmlTime(e.Changed),
+			OldPassword: e.Password,
+		})
+	}
+	return x
+}
+
+func newXMLNamedPolicy(p namedPolicy) xmlNamedPolicy {
+	return xmlNamedPolicy{
+		Name:           p.name,
+		xmlPolicyFlags: newXMLPolicyFlags(p.flags),
+		Length:         p.length,
+		Digits:         p.minDigits,
+		Lowercase:      p.minLower,
+		Symbols:        p.minSymbols,
+		Uppercase:      p.minUpper,
+		OwnSymbols:     p.symbols,
+	}
+}
+
+func xmlTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(XMLTimeLayout)
+}
+
+// xmlText is true if the string can be written as XML text without
+// changing.
+func xmlText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadXML reads a safe written by WriteXML, or an XML file in the client's
+// layout.
+func ReadXML(r io.Reader) (V3File, error) {
+	var safe xmlSafe
+	if err := xml.NewDecoder(r).Decode(&safe); err != nil {
+		return V3File{}, err
+	}
+
+	var v3 V3File
+	addHeader := func(typeID byte, raw []byte) {
+		h, err := NewHeader(typeID, raw)
+		if err != nil {
+			// kept as it is, like Load does
+			h = HeaderRecord{Type: typeID, Data: raw}
+		}
+		v3.Headers = append(v3.Headers, h)
+	}
+	if safe.Format != "" {
+		raw, err := versionBytes(safe.Format)
+		if err != nil {
+			return V3File{}, fmt.Errorf("FromDatabaseFormat: %w", err)
+		}
+		addHeader(Version, raw)
+	}
+	if safe.DatabaseUUID != "" {
+		raw, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(safe.DatabaseUUID), "-", ""))
+		if err != nil {
+			return V3File{}, fmt.Errorf("Database_uuid: %w", err)
+		}
+		addHeader(UUID, raw)
+	}
+	if safe.WhenLastSaved != "" {
+		raw, err := xmlTimeBytes(safe.WhenLastSaved)
+		if err != nil {
+			return V3File{}, fmt.Errorf("WhenLastSaved: %w", err)
+		}
+		addHeader(TimestampOfLastSave, raw)
+	}
+	if safe.WhatSaved != "" {
+		addHeader(WhatPerformedLastSave, []byte(safe.WhatSaved))
+	}
+	for _, u := range safe.UnknownHeaders.list() {
+		typeID, raw, err := u.raw()
+		if err != nil {
+			return V3File{}, fmt.Errorf("unknownheaderfields: %w", err)
+		}
+		addHeader(typeID, raw)
+	}
+	if safe.Policies != nil && len(safe.Policies.Policies) > 0 {
+		var policies []namedPolicy
+		for _, p := range safe.Policies.Policies {
+			policies = append(policies, p.policy())
+		}
+		addHeader(NamedPasswordPolicies, []byte(formatNamedPolicies(policies)))
+	}
+	if safe.EmptyGroups != nil {
+		for _, g := range safe.EmptyGroups.Names {
+			addHeader(EmptyGroups, []byte(g))
+		}
+	}
+
+	for i, e := range safe.Entries {
+		p, err := e.record()
+		if err != nil {
+			return V3File{}, fmt.Errorf("entry %d %w", i+1, err)
+		}
+		v3.Passwords = append(v3.Passwords, p)
+	}
+	return v3, nil
+}
+
+func (e *xmlEntry) record() (PasswordRecord, error) {
+	p := NewPasswordRecord()
+	add := func(typeID byte, raw []byte) {
+		if err := p.AddField(typeID, raw); err != nil {
+			// kept as it is, like Load does
+			p.Fields[typeID] = Field{Type: typeID, Data: raw}
+		}
+	}
+	for _, u := range e.Unknown.list() {
+		typeID, raw, err := u.raw()
+		if err != nil {
+			return PasswordRecord{}, fmt.Errorf("unknownrecordfields: %w", err)
+		}
+		add(typeID, raw)
+	}
+	for typeID, s := range e.texts() {
+		if *s != nil {
+			add(typeID, []byte(**s))
+		}
+	}
+	for typeID, s := range e.times() {
+		if *s == "" {
+			continue
+		}
+		raw, err := xmlTimeBytes(*s)
+		if err != nil {
+			return PasswordRecord{}, fmt.Errorf("%s: %w", FieldName(typeID), err)
+		}
+		add(typeID, raw)
+	}
+	for typeID, n := range e.numbers() {
+		if *n.value == "" {
+			continue
+		}
+		raw, err := n.raw()
+		if err != nil {
+			return PasswordRecord{}, fmt.Errorf("%s: %w", FieldName(typeID), err)
+		}
+		add(typeID, raw)
+	}
+	if e.UUID != "" {
+		raw, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(e.UUID), "-", ""))
+		if err != nil {
+			return PasswordRecord{}, fmt.Errorf("uuid: %w", err)
+		}
+		add(UUID, raw)
+	}
+	if e.History != nil {
+		h, err := e.History.history()
+		if err != nil {
+			return PasswordRecord{}, fmt.Errorf("pwhistory: %w", err)
+		}
+		add(PasswordHistory, []byte(h.String()))
+	}
+	if e.Policy != nil {
+		add(PasswordPolicy, []byte(e.Policy.policy().String()))
+	}
+	return p, nil
+}
+
+// raw converts a number back to how it's stored in the safe.
+func (n xmlNumber) raw() ([]byte, error) {
+	s := strings.TrimSpace(*n.value)
+	// protected is a boolean
+	switch s {
+	case "true":
+		s = "1"
+	case "false":
+		s = "0"
+	}
+	v, err := strconv.ParseUint(s, 10, n.size*8)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return b[:n.size], nil
+}
+
+func (u *xmlUnknown) raw() (byte, []byte, error) {
+	if u.Type < 0 || u.Type > 0xff {
+		return 0, nil, fmt.Errorf("bad ftype %d", u.Type)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(u.Value))
+	if err != nil {
+		return 0, nil, fmt.Errorf("ftype %d: %w", u.Type, err)
+	}
+	return byte(u.Type), raw, nil
+}
+
+func (x *xmlHistory) history() (History, error) {
+	h := History{Enabled: x.Status != 0, Max: x.Max}
+	for _, e := range x.Entries {
+		t, err := parseXMLTime(e.Changed)
+		if err != nil {
+			return History{}, err
+		}
+		h.Entries = append(h.Entries, HistoryEntry{Changed: t, Password: e.OldPassword})
+	}
+	return h, nil
+}
+
+func (x *xmlPolicy) policy() passwordPolicy {
+	return passwordPolicy{
+		flags:      x.xmlPolicyFlags.flags(),
+		length:     x.Length,
+		minLower:   x.Lowercase,
+		minUpper:   x.Uppercase,
+		minDigits:  x.Digits,
+		minSymbols: x.Symbols,
+	}
+}
+
+func (x *xmlNamedPolicy) policy() namedPolicy {
+	return namedPolicy{
+		name: x.Name,
+		passwordPolicy: passwordPolicy{
+			flags:      x.xmlPolicyFlags.flags(),
+			length:     x.Length,
+			minLower:   x.Lowercase,
+			minUpper:   x.Uppercase,
+			minDigits:  x.Digits,
+			minSymbols: x.Symbols,
+		},
+		symbols: x.OwnSymbols,
+	}
+}
+
+// the policy flags, in the order of the elements
+var policyFlags = []uint16{0x2000, 0x0400, 0x0800, 0x8000, 0x1000, 0x4000, 0x0200}
+
+func (f *xmlPolicyFlags) fields() []*int {
+	return []*int{&f.UseDigits, &f.UseEasyVision, &f.UseHexDigits, &f.UseLowercase,
+		&f.UseSymbols, &f.UseUppercase, &f.MakePronounceable}
+}
+
+func newXMLPolicyFlags(flags uint16) xmlPolicyFlags {
+	var f xmlPolicyFlags
+	for i, v := range f.fields() {
+		if flags&policyFlags[i] != 0 {
+			*v = 1
+		}
+	}
+	return f
+}
+
+func (f *xmlPolicyFlags) flags() uint16 {
+	var flags uint16
+	for i, v := range f.fields() {
+		if *v != 0 {
+			flags |= policyFlags[i]
+		}
+	}
+	return flags
+}
+
+func parseXMLTime(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", s, time.Local)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint32(t.Unix()), nil
+}
+
+func xmlTimeBytes(s string) ([]byte, error) {
+	t, err := parseXMLTime(s)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, t)
+	return b, nil
+}
+
+func versionBytes(s string) ([]byte, error) {
+	var major, minor byte
+	if _, err := fmt.Sscanf(s, "%d.%d", &major, &minor); err != nil {
+		return nil, fmt.Errorf("bad version %q", s)
+	}
+	return []byte{minor, major}, nil
+}
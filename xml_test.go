@@ -0,0 +1,186 @@
+package pwsafe_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	pwsafe "github.com/colinnewell/pwsafe-de-dup"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestXMLRoundTrip(t *testing.T) {
+	r := record(uuid.New(), "title", "user", "pass <&> word")
+	for k, v := range map[byte]interface{}{
+		pwsafe.Group:                    `web.example\.com`,
+		pwsafe.Notes:                    "line 1\r\nline 2\n  indented ",
+		pwsafe.URL:                      "https://example.com/?a=1&b=2",
+		pwsafe.CreationTime:             uint32(1600000000),
+		pwsafe.LastModificationTime:     uint32(1600000100),
+		pwsafe.PasswordHistory:          "10302" + "5f5e1000" + "0003" + "old" + "5f5e1001" + "0005" + "older",
+		pwsafe.TwoFactorKey:             []byte{0, 1, 2, 0xff},
+		pwsafe.DoubleClickAction:        []byte{1, 0},
+		pwsafe.PasswordPolicyName:       "strict",
+		pwsafe.CreditCardNumber:         "4111111111111111",
+		pwsafe.EMailAddress:             "user@example.com",
+		pwsafe.OwnSymbolsForPassword:    "\x01 not valid in XML",
+		pwsafe.PasswordModificationTime: []byte{1, 2},
+		pwsafe.PasswordPolicy:           "f00001400100100200" + "1",
+		pwsafe.ShiftDoubleClickAction:   []byte{0xff, 0xff},
+		pwsafe.PasswordExpiryInterval:   []byte{90, 0, 0, 0},
+		pwsafe.ProtectedEntry:           []byte{1},
+		0x40:                            []byte("unknown"),
+	} {
+		r.Fields[k] = pwsafe.Field{Type: k, Data: v}
+	}
+	bad := record(uuid.New(), "bad history", "user", "pass")
+	bad.Fields[pwsafe.PasswordHistory] = pwsafe.Field{Type: pwsafe.PasswordHistory, Data: "1FF00"}
+
+	v3 := pwsafe.V3File{
+		Headers: []pwsafe.HeaderRecord{
+			// in the order they're read back
+			{Type: pwsafe.Version, Data: "3.13"},
+			{Type: pwsafe.UUID, Data: uuid.New()},
+			{Type: pwsafe.TimestampOfLastSave, Data: uint32(1600000200)},
+			{Type: pwsafe.DatabaseName, Data: "test"},
+			{Type: pwsafe.LastMasterPasswordChange, Data: uint32(1600000300)},
+			{Type: 0x30, Data: []byte{9}},
+			{Type: pwsafe.NamedPasswordPolicies, Data: "01" + "06strict" + "f000014001001002001" + "02!@"},
+			{Type: pwsafe.EmptyGroups, Data: "empty"},
+			{Type: pwsafe.EmptyGroups, Data: "also.empty"},
+		},
+		Passwords: []pwsafe.PasswordRecord{r, bad, pwsafe.NewPasswordRecord()},
+	}
+
+	var b bytes.Buffer
+	if err := pwsafe.WriteXML(&b, &v3); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []string{
+		`FromDatabaseFormat="3.13"`,
+		"<ctimex>2020-09-13T12:26:40Z</ctimex>",
+		"<dca>1</dca>",
+		"<shiftdca>65535</shiftdca>",
+		"<xtime_interval>90</xtime_interval>",
+		"<protected>1</protected>",
+		"<PWLength>20</PWLength>",
+		"<PWName>strict</PWName>",
+		"<EGName>also.empty</EGName>",
+	} {
+		if !strings.Contains(b.String(), x) {
+			t.Errorf("Expected %s in:\n%s", x, b.String())
+		}
+	}
+	read, err := pwsafe.ReadXML(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(v3, read); diff != "" {
+		t.Errorf("Round trip not identical (-wrote +read):\n%s\n", diff)
+	}
+}
+
+func TestReadXMLClient(t *testing.T) {
+	// laid out as pwsafe.xsd has it, with local times without a zone and
+	// CDATA as the desktop client writes them
+	x := `<?xml version="1.0" encoding="UTF-8"?>
+<passwordsafe delimiter="»" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="pwsafe.xsd" ExportTimeStamp="2020-09-13T12:30:00" FromDatabaseFormat="3.13" WhoSaved="user on host" WhatSaved="Password Safe V3.52" WhenLastSaved="2020-09-13T12:26:40" Database_uuid="1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f">
+<NamedPasswordPolicies>
+  <Policy>
+    <PWName><![CDATA[strict]]></PWName>
+    <PWUseDigits>1</PWUseDigits>
+    <PWUseLowercase>1</PWUseLowercase>
+    <PWUseSymbols>1</PWUseSymbols>
+    <PWUseUppercase>1</PWUseUppercase>
+    <PWDefaultLength>20</PWDefaultLength>
+    <PWDefaultDigitCount>2</PWDefaultDigitCount>
+    <PWDefaultLowerCaseCount>1</PWDefaultLowerCaseCount>
+    <PWDefaultSymbolCount>1</PWDefaultSymbolCount>
+    <PWDefaultUpperCaseCount>1</PWDefaultUpperCaseCount>
+    <symbols><![CDATA[!@]]></symbols>
+  </Policy>
+</NamedPasswordPolicies>
+<EmptyGroups>
+  <EGName><![CDATA[web.old]]></EGName>
+</EmptyGroups>
+<entry>
+  <group><![CDATA[web]]></group>
+  <title><![CDATA[bank]]></title>
+  <password><![CDATA[secret]]></password>
+  <uuid><![CDATA[5ffe0731ae904cb0b3d7db40855d8ceb]]></uuid>
+  <ctimex>2020-09-13T12:26:40</ctimex>
+  <xtime_interval>90</xtime_interval>
+  <pwhistory>
+    <status>1</status>
+    <max>3</max>
+    <num>1</num>
+    <history_entries>
+      <history_entry num="1">
+        <changedx>2020-09-13T12:26:40</changedx>
+        <oldpassword><![CDATA[old]]></oldpassword>
+      </history_entry>
+    </history_entries>
+  </pwhistory>
+  <PasswordPolicy>
+    <PWLength>12</PWLength>
+    <PWUseDigits>1</PWUseDigits>
+    <PWUseLowercase>1</PWUseLowercase>
+    <PWLowercaseMinLength>1</PWLowercaseMinLength>
+    <PWUppercaseMinLength>0</PWUppercaseMinLength>
+    <PWDigitMinLength>3</PWDigitMinLength>
+    <PWSymbolMinLength>0</PWSymbolMinLength>
+  </PasswordPolicy>
+  <dca>1</dca>
+  <shiftdca>5</shiftdca>
+  <protected>1</protected>
+</entry>
+</passwordsafe>`
+	v3, err := pwsafe.ReadXML(strings.NewReader(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := time.ParseInLocation("2006-01-02T15:04:05", "2020-09-13T12:26:40", time.Local)
+	expectedHeaders := []pwsafe.HeaderRecord{
+		{Type: pwsafe.Version, Data: "3.13"},
+		{Type: pwsafe.UUID, Data: uuid.MustParse("1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f")},
+		{Type: pwsafe.TimestampOfLastSave, Data: uint32(saved.Unix())},
+		{Type: pwsafe.WhatPerformedLastSave, Data: "Password Safe V3.52"},
+		{Type: pwsafe.NamedPasswordPolicies, Data: "01" + "06strict" + "f000014001001002001" + "02!@"},
+		{Type: pwsafe.EmptyGroups, Data: "web.old"},
+	}
+	if diff := cmp.Diff(expectedHeaders, v3.Headers); diff != "" {
+		t.Errorf("Unexpected headers (-want +got):\n%s\n", diff)
+	}
+	if len(v3.Passwords) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(v3.Passwords))
+	}
+	p := v3.Passwords[0]
+	if id, _ := p.ID(); id.String() != "5ffe0731-ae90-4cb0-b3d7-db40855d8ceb" {
+		t.Errorf("Unexpected UUID %s", id)
+	}
+	if p.Text(pwsafe.Title) != "bank" || p.Text(pwsafe.Password) != "secret" {
+		t.Errorf("Unexpected record %s", p.Redacted(pwsafe.RevealAll))
+	}
+	h, err := pwsafe.ParseHistory(p.Text(pwsafe.PasswordHistory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Entries) != 1 || h.Entries[0].Password != "old" {
+		t.Errorf("Unexpected history %+v", h)
+	}
+	// the numbers are stored as they are in a psafe3
+	expected := map[byte]interface{}{
+		pwsafe.PasswordPolicy:         "a00000c001000003000",
+		pwsafe.PasswordExpiryInterval: []byte{90, 0, 0, 0},
+		pwsafe.DoubleClickAction:      []byte{1, 0},
+		pwsafe.ShiftDoubleClickAction: []byte{5, 0},
+		pwsafe.ProtectedEntry:         []byte{1},
+	}
+	for k, v := range expected {
+		if diff := cmp.Diff(v, p.Fields[k].Data); diff != "" {
+			t.Errorf("Unexpected %s (-want +got):\n%s\n", pwsafe.FieldName(k), diff)
+		}
+	}
+}